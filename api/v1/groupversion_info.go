@@ -1,3 +1,6 @@
+// Package v1 contains API Schema definitions for the devops v1 API group.
+// +kubebuilder:object:generate=true
+// +groupName=devops.example.com
 package v1
 
 import (
@@ -15,12 +18,3 @@ var (
 	// AddToScheme adds the types in this group-version to the given scheme.
 	AddToScheme = SchemeBuilder.AddToScheme
 )
-
-func init() {
-	// Register types for GCP, AWS, and Azure configurations
-	SchemeBuilder.Register(
-		&GCPConfigSpec{},
-		&AWSConfigSpec{},
-		&AzureConfigSpec{},
-	)
-}
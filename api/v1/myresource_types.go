@@ -1,71 +1,539 @@
 package v1
 
 import (
-    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// GCPConfigSpec holds the parameters for provisioning resources on GCP.
+// +kubebuilder:validation:XValidation:rule="!has(self.region) || !has(self.zone) || self.zone.startsWith(self.region + '-')",message="zone must be in region"
 type GCPConfigSpec struct {
-    ProjectID string `json:"projectID,omitempty"`
-    Region string `json:"region,omitempty"`
-    Zone string `json:"zone,omitempty"`
-    MachineType string `json:"machineType,omitempty"`
-    CredentialsSecretRef string `json:"credentialsSecretRef,omitempty"`
+	// Name of the GCP project to provision resources in
+	ProjectID string `json:"projectID,omitempty"`
+	// Region in which resources should be deployed, e.g., "us-central1"
+	Region string `json:"region,omitempty"`
+	// Zone can be used if you need granular control, e.g., "us-central1-a"
+	Zone string `json:"zone,omitempty"`
+	// Machine type for Compute Engine, e.g., "e2-medium", "n1-standard-1", etc.
+	MachineType string `json:"machineType,omitempty"`
+	// Image is the image to boot, as a URL or "projects/<project>/global/images/<name>".
+	// Takes precedence over ImageFamily.
+	// +optional
+	Image string `json:"image,omitempty"`
+	// ImageFamily boots the latest image in the family, e.g. "debian-12". When
+	// neither Image nor ImageFamily is set, "debian-11" from "debian-cloud" is used.
+	// +optional
+	ImageFamily string `json:"imageFamily,omitempty"`
+	// ImageProject is the project hosting ImageFamily, e.g. "debian-cloud".
+	// Defaults to ProjectID.
+	// +optional
+	ImageProject string `json:"imageProject,omitempty"`
+	// CredentialsSecretRef names a Secret in the MyResource's namespace holding a
+	// service account key under "credentials.json". When empty the controller's
+	// application default credentials are used.
+	// +optional
+	CredentialsSecretRef string `json:"credentialsSecretRef,omitempty"`
+
+	// Network is the VPC network name or URL. Defaults to the "default" network.
+	// +optional
+	Network string `json:"network,omitempty"`
+	// Subnetwork is the subnetwork name or URL, required for custom-mode networks.
+	// A bare name is looked up in the zone's region.
+	// +optional
+	Subnetwork string `json:"subnetwork,omitempty"`
+	// ExternalIP gives each instance an ephemeral external IP address. Defaults to true.
+	// +optional
+	ExternalIP *bool `json:"externalIP,omitempty"`
+	// NetworkTags are applied to every instance, e.g. to match firewall rules.
+	// +optional
+	NetworkTags []string `json:"networkTags,omitempty"`
+	// Labels are added to every instance and boot disk. The ownership labels
+	// written by the controller take precedence.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// ServiceAccount is the identity the instances run as.
+	// +optional
+	ServiceAccount *GCPServiceAccount `json:"serviceAccount,omitempty"`
+	// BootDisk configures the boot disk.
+	// +optional
+	BootDisk *GCPBootDisk `json:"bootDisk,omitempty"`
+	// ShieldedVM enables Shielded VM features. The image must support them.
+	// +optional
+	ShieldedVM *GCPShieldedVM `json:"shieldedVM,omitempty"`
+}
+
+// GCPServiceAccount attaches a service account to GCE instances.
+type GCPServiceAccount struct {
+	// Email of the service account.
+	Email string `json:"email"`
+	// Scopes are the OAuth scopes granted to the instances.
+	// +kubebuilder:default={"https://www.googleapis.com/auth/cloud-platform"}
+	// +optional
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// GCPBootDisk configures the boot disk of GCE instances.
+type GCPBootDisk struct {
+	// SizeGB is the disk size. Defaults to the image size.
+	// +kubebuilder:validation:Minimum=10
+	// +optional
+	SizeGB int64 `json:"sizeGB,omitempty"`
+	// Type is the disk type, e.g. "pd-balanced" or "pd-ssd".
+	// +optional
+	Type string `json:"type,omitempty"`
+}
+
+// GCPShieldedVM toggles Shielded VM features.
+type GCPShieldedVM struct {
+	// SecureBoot verifies the boot loader and kernel signatures.
+	// +optional
+	SecureBoot bool `json:"secureBoot,omitempty"`
+	// VTPM enables the virtual Trusted Platform Module.
+	// +optional
+	VTPM bool `json:"vTPM,omitempty"`
+	// IntegrityMonitoring monitors the boot integrity of the instance. Requires VTPM.
+	// +optional
+	IntegrityMonitoring bool `json:"integrityMonitoring,omitempty"`
 }
 
+// +kubebuilder:validation:XValidation:rule="!has(self.region) || !has(self.placement) || !has(self.placement.availabilityZone) || self.placement.availabilityZone.startsWith(self.region)",message="placement.availabilityZone must be in region"
 type AWSConfigSpec struct {
-    Region             string `json:"region,omitempty"`
-    InstanceType       string `json:"instanceType,omitempty"`
-    AdminUsername      string `json:"adminUsername,omitempty"`
-    AdminPassword      string `json:"adminPassword,omitempty"`
-    NetworkInterfaceID string `json:"networkInterfaceID,omitempty"`
-    SubscriptionID     string `json:"subscriptionID,omitempty"`
-    ResourceGroup      string `json:"resourceGroup,omitempty"`
+	Region       string `json:"region,omitempty"`
+	InstanceType string `json:"instanceType,omitempty"`
+	// ImageID is the AMI to launch, e.g. "ami-0abcdef1234567890". Takes precedence over ImageLookup.
+	// +optional
+	ImageID string `json:"imageID,omitempty"`
+	// ImageLookup selects the newest available AMI matching an owner and name filter.
+	// +optional
+	ImageLookup *AWSImageLookup `json:"imageLookup,omitempty"`
+
+	AdminUsername string `json:"adminUsername,omitempty"`
+	// NetworkInterfaceID is ignored.
+	//
+	// Deprecated: EC2 instances get their network interface from SubnetIDs.
+	// +optional
+	NetworkInterfaceID string `json:"networkInterfaceID,omitempty"`
+	// SubscriptionID is ignored.
+	//
+	// Deprecated: Azure setting with no meaning on AWS.
+	// +optional
+	SubscriptionID string `json:"subscriptionID,omitempty"`
+	// ResourceGroup is ignored.
+	//
+	// Deprecated: Azure setting with no meaning on AWS.
+	// +optional
+	ResourceGroup string `json:"resourceGroup,omitempty"`
+	// AdminPasswordSecretRef selects the key of a Secret in the MyResource's
+	// namespace holding the admin password. EC2 cannot set a password at launch,
+	// so no password is generated when this is unset.
+	// +optional
+	AdminPasswordSecretRef *corev1.SecretKeySelector `json:"adminPasswordSecretRef,omitempty"`
+	// CredentialsSecretRef names a Secret in the MyResource's namespace holding
+	// "accessKeyID", "secretAccessKey" and optionally "sessionToken". When empty
+	// the controller's default AWS credential chain is used.
+	// +optional
+	CredentialsSecretRef string `json:"credentialsSecretRef,omitempty"`
+
+	// KeyName is an existing EC2 key pair to launch instances with.
+	// +optional
+	KeyName string `json:"keyName,omitempty"`
+	// ImportKeyPair imports the first key of spec.ssh as an EC2 key pair owned by
+	// the MyResource. The key pair is deleted along with the instances under the
+	// Delete deletion policy. Ignored when KeyName is set.
+	// +optional
+	ImportKeyPair *bool `json:"importKeyPair,omitempty"`
+
+	// SubnetIDs are the subnets to launch instances in. Instances are spread
+	// across them round-robin by ordinal. When empty the default VPC is used.
+	// +optional
+	SubnetIDs []string `json:"subnetIDs,omitempty"`
+	// SecurityGroupIDs are attached to every instance.
+	// +optional
+	SecurityGroupIDs []string `json:"securityGroupIDs,omitempty"`
+	// IAMInstanceProfile is the name or ARN of the instance profile to launch with.
+	// +optional
+	IAMInstanceProfile string `json:"iamInstanceProfile,omitempty"`
+	// RootVolume overrides the AMI's root EBS volume.
+	// +optional
+	RootVolume *AWSRootVolume `json:"rootVolume,omitempty"`
+	// RequireIMDSv2 makes the instance metadata service require session tokens.
+	// +optional
+	RequireIMDSv2 *bool `json:"requireIMDSv2,omitempty"`
+	// Placement controls where instances are placed.
+	// +optional
+	Placement *AWSPlacement `json:"placement,omitempty"`
+}
+
+// AWSRootVolume configures the root EBS volume of an EC2 instance.
+type AWSRootVolume struct {
+	// SizeGiB is the volume size. Defaults to the AMI's snapshot size.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	SizeGiB int64 `json:"sizeGiB,omitempty"`
+	// Type is the EBS volume type.
+	// +kubebuilder:validation:Enum=gp2;gp3;io1;io2;st1;sc1;standard
+	// +optional
+	Type string `json:"type,omitempty"`
+	// Encrypted encrypts the volume.
+	// +optional
+	Encrypted bool `json:"encrypted,omitempty"`
+	// KMSKeyID is the KMS key used to encrypt the volume. Defaults to the
+	// account's EBS default key. Setting it implies Encrypted.
+	// +optional
+	KMSKeyID string `json:"kmsKeyID,omitempty"`
+}
+
+// AWSPlacement places EC2 instances.
+type AWSPlacement struct {
+	// AvailabilityZone to launch in, e.g. "us-east-1a". Must match the subnets, if set.
+	// +optional
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+	// GroupName is the placement group to launch in.
+	// +optional
+	GroupName string `json:"groupName,omitempty"`
+	// Tenancy of the instances.
+	// +kubebuilder:validation:Enum=default;dedicated;host
+	// +optional
+	Tenancy string `json:"tenancy,omitempty"`
+}
+
+// AWSImageLookup selects an AMI by owner and name.
+type AWSImageLookup struct {
+	// Owners are the AMI owners, as account IDs or aliases such as "amazon".
+	// +kubebuilder:validation:MinItems=1
+	Owners []string `json:"owners"`
+	// Name is the AMI name filter; "*" and "?" are wildcards,
+	// e.g. "ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-*".
+	Name string `json:"name"`
 }
 
 type AzureConfigSpec struct {
-    Region             string `json:"region,omitempty"`
-    VMSize             string `json:"vmSize,omitempty"`
-    ImagePublisher     string `json:"imagePublisher,omitempty"`
-    ImageOffer         string `json:"imageOffer,omitempty"`
-    ImageSKU           string `json:"imageSKU,omitempty"`
-    ImageVersion       string `json:"imageVersion,omitempty"`
-    AdminUsername      string `json:"adminUsername,omitempty"`
-    AdminPassword      string `json:"adminPassword,omitempty"`
-    NetworkInterfaceID string `json:"networkInterfaceID,omitempty"`
-    SubscriptionID     string `json:"subscriptionID,omitempty"`
-    ResourceGroup      string `json:"resourceGroup,omitempty"`
+	Region         string `json:"region,omitempty"`
+	VMSize         string `json:"vmSize,omitempty"`
+	ImagePublisher string `json:"imagePublisher,omitempty"`
+	ImageOffer     string `json:"imageOffer,omitempty"`
+	ImageSKU       string `json:"imageSKU,omitempty"`
+	ImageVersion   string `json:"imageVersion,omitempty"`
+	AdminUsername  string `json:"adminUsername,omitempty"`
+	// NetworkInterfaceID attaches every VM to one existing NIC.
+	//
+	// Deprecated: a NIC can only belong to one VM, so this only works for a
+	// single instance. Set SubnetID instead.
+	// +optional
+	NetworkInterfaceID string `json:"networkInterfaceID,omitempty"`
+	SubscriptionID     string `json:"subscriptionID,omitempty"`
+	ResourceGroup      string `json:"resourceGroup,omitempty"`
+	// SubnetID is the resource ID of the subnet each VM gets its own NIC in, e.g.
+	// "/subscriptions/<id>/resourceGroups/<rg>/providers/Microsoft.Network/virtualNetworks/<vnet>/subnets/<subnet>".
+	// +optional
+	SubnetID string `json:"subnetID,omitempty"`
+	// PublicIP gives each VM a static Standard SKU public IP address. Requires SubnetID.
+	// +optional
+	PublicIP *bool `json:"publicIP,omitempty"`
+	// AdminPasswordSecretRef selects the key of a Secret in the MyResource's
+	// namespace holding the password for AdminUsername. When unset, the controller
+	// generates a password into the Secret "<name>-admin-password" under the key
+	// "password", owned by the MyResource so it is deleted along with it.
+	// +optional
+	AdminPasswordSecretRef *corev1.SecretKeySelector `json:"adminPasswordSecretRef,omitempty"`
+	// CredentialsSecretRef names a Secret in the MyResource's namespace holding a
+	// service principal: "tenantID", "clientID" and either "clientSecret" or
+	// "clientCertificate" (optionally with "clientCertificatePassword"). When
+	// empty the controller's default Azure credential is used.
+	// +optional
+	CredentialsSecretRef string `json:"credentialsSecretRef,omitempty"`
+}
+
+// SSHSpec lists SSH public keys to authorize on new instances. They are written
+// to the GCE "ssh-keys" metadata item and to the Azure Linux SSH configuration,
+// which also disables password login. EC2 takes a single key pair instead; see
+// AWSConfigSpec.KeyName and AWSConfigSpec.ImportKeyPair.
+type SSHSpec struct {
+	// Username is the account the keys are authorized for on GCE. Azure always
+	// uses azureConfig.adminUsername.
+	// +kubebuilder:default=myresource
+	// +optional
+	Username string `json:"username,omitempty"`
+	// PublicKeys are OpenSSH public keys, e.g. "ssh-ed25519 AAAA... user@host".
+	// +optional
+	PublicKeys []string `json:"publicKeys,omitempty"`
+	// PublicKeysSecretRef selects a Secret key holding further public keys, one per line.
+	// +optional
+	PublicKeysSecretRef *corev1.SecretKeySelector `json:"publicKeysSecretRef,omitempty"`
+}
+
+// BootstrapSpec supplies the user data for new instances from exactly one source.
+// It is passed as EC2 UserData, the GCE "startup-script" metadata item or Azure CustomData.
+type BootstrapSpec struct {
+	// Script is the inline user data, e.g. a shell script or cloud-config document.
+	// +optional
+	Script string `json:"script,omitempty"`
+	// ConfigMapRef selects a key of a ConfigMap in the MyResource's namespace holding the user data.
+	// +optional
+	ConfigMapRef *corev1.ConfigMapKeySelector `json:"configMapRef,omitempty"`
+	// SecretRef selects a key of a Secret in the MyResource's namespace holding the
+	// user data, for scripts that embed credentials.
+	// +optional
+	SecretRef *corev1.SecretKeySelector `json:"secretRef,omitempty"`
+
+	// Template renders the user data as a Go text/template for each instance.
+	// The available variables are:
+	//   .Index      0-based ordinal of the instance within the MyResource
+	//   .Name       name of the MyResource
+	//   .Namespace  namespace of the MyResource
+	//   .Provider   cloud provider: gcp, aws or azure
+	//   .Region     region from the provider config
+	//   .Zone       zone from the provider config, if it sets one
+	//   .JoinToken  value selected by JoinTokenSecretRef
+	// Referencing any other variable fails the reconcile with an InvalidBootstrap condition.
+	// +optional
+	Template bool `json:"template,omitempty"`
+	// JoinTokenSecretRef selects a Secret key exposed to the template as .JoinToken,
+	// e.g. a token instances use to join a cluster.
+	// +optional
+	JoinTokenSecretRef *corev1.SecretKeySelector `json:"joinTokenSecretRef,omitempty"`
 }
 
+// DeletionPolicy decides what happens to the cloud instances of a MyResource when it is deleted.
+// +kubebuilder:validation:Enum=Delete;Retain;Orphan
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete terminates the instances before the MyResource goes away.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyRetain leaves the instances running and still tagged with their owner.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyOrphan leaves the instances running and strips the owner UID tag
+	// but keeps the namespace and name tags. This is deliberate: a MyResource later
+	// created with the same namespace and name adopts the instances and counts them
+	// toward its desiredCount. A MyResource with any other name never touches them.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+)
+
 // MyResourceSpec defines the desired state of MyResource.
+// +kubebuilder:validation:XValidation:rule="(has(self.gcpConfig) ? 1 : 0) + (has(self.awsConfig) ? 1 : 0) + (has(self.azureConfig) ? 1 : 0) == 1",message="exactly one of gcpConfig, awsConfig and azureConfig must be set"
+// +kubebuilder:validation:XValidation:rule="!has(self.provider) || (self.provider == 'gcp' ? has(self.gcpConfig) : self.provider == 'aws' ? has(self.awsConfig) : has(self.azureConfig))",message="provider must match the config that is set"
+// +kubebuilder:validation:XValidation:rule="!has(oldSelf.gcpConfig) || !has(oldSelf.gcpConfig.projectID) || !has(self.gcpConfig) || (has(self.gcpConfig.projectID) && self.gcpConfig.projectID == oldSelf.gcpConfig.projectID)",message="gcpConfig.projectID is immutable once set"
+// +kubebuilder:validation:XValidation:rule="!has(oldSelf.azureConfig) || !has(oldSelf.azureConfig.subscriptionID) || !has(self.azureConfig) || (has(self.azureConfig.subscriptionID) && self.azureConfig.subscriptionID == oldSelf.azureConfig.subscriptionID)",message="azureConfig.subscriptionID is immutable once set"
 type MyResourceSpec struct {
-    DesiredCount int `json:"desiredCount,omitempty"`
-    GCPConfig    *GCPConfigSpec    `json:"gcpConfig,omitempty"`
-    AWSConfig    *AWSConfigSpec    `json:"awsConfig,omitempty"`
-    AzureConfig  *AzureConfigSpec  `json:"azureConfig,omitempty"`
+	// DesiredCount is how many instances you want to run.
+	// The controller will reconcile the current number of instances
+	// with this desired count.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=1000
+	DesiredCount int `json:"desiredCount,omitempty"`
+	// Provider names the cloud the instances run in. The defaulting webhook
+	// sets it from whichever config is present, or, when none is, creates
+	// the config for this provider from the cluster and namespace defaults.
+	// +kubebuilder:validation:Enum=gcp;aws;azure
+	// +optional
+	Provider string `json:"provider,omitempty"`
+	// GCPConfig holds the parameters for provisioning resources on GCP.
+	GCPConfig   *GCPConfigSpec   `json:"gcpConfig,omitempty"`
+	AWSConfig   *AWSConfigSpec   `json:"awsConfig,omitempty"`
+	AzureConfig *AzureConfigSpec `json:"azureConfig,omitempty"`
+
+	// NamePrefix starts the name of every instance, which is followed by a
+	// short hash of the MyResource's UID and the instance's ordinal, e.g.
+	// "web-3f9c2-0". Defaults to the MyResource name, cut to 40 characters.
+	// +kubebuilder:validation:MaxLength=40
+	// +optional
+	NamePrefix string `json:"namePrefix,omitempty"`
+
+	// NameTemplate is a Go template for instance names that replaces the
+	// default "{{ .Prefix }}-{{ .Hash }}-{{ .Index }}". The variables are
+	// .Prefix, .Name, .Namespace, .Hash and .Index, and the result must
+	// differ per .Index. Names are rewritten to fit each cloud's rules, e.g.
+	// lowercased and shortened for GCE. Scaling down removes the highest
+	// .Index first, after any instances whose names the template did not produce.
+	// +optional
+	NameTemplate string `json:"nameTemplate,omitempty"`
+
+	// SSH lists the public keys authorized on new instances.
+	// +optional
+	SSH *SSHSpec `json:"ssh,omitempty"`
+
+	// Bootstrap is the user data new instances run on first boot.
+	// +optional
+	Bootstrap *BootstrapSpec `json:"bootstrap,omitempty"`
+
+	// Tags are written on every instance as EC2 tags, GCE labels or Azure tags.
+	// Keys and values are rewritten to fit each cloud's restrictions, and tags
+	// changed on the instances out of band are set back.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// PropagateLabels lists keys of metadata.labels to copy into the instance
+	// tags. Tags with the same key take precedence.
+	// +optional
+	PropagateLabels []string `json:"propagateLabels,omitempty"`
+
+	// DeletionPolicy controls what happens to the cloud instances when the MyResource is deleted.
+	// Instances released with Orphan are adopted by the next MyResource created
+	// with the same namespace and name.
+	// If the credentials Secret is deleted first, as namespace deletion may do,
+	// Delete waits for the Secret to be restored or the finalizer to be removed,
+	// and Orphan releases the instances with their owner UID tag still set.
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
 }
 
+// Condition types reported in MyResourceStatus.Conditions.
+const (
+	// ConditionReady is True when DesiredCount instances exist and are running.
+	ConditionReady = "Ready"
+	// ConditionProgressing is True while instances are being created, deleted or are still starting.
+	ConditionProgressing = "Progressing"
+	// ConditionDegraded is True when the last reconcile failed or the spec is invalid.
+	ConditionDegraded = "Degraded"
+	// ConditionCredentialsValid reports whether the controller could authenticate against the cloud.
+	ConditionCredentialsValid = "CredentialsValid"
+	// ConditionDeleting is True while the MyResource is being finalized.
+	ConditionDeleting = "Deleting"
+)
+
+// MyResourceLabel is the label key of the selector published in
+// status.selector for the scale subresource, with the MyResource name as value.
+const MyResourceLabel = "devops.example.com/myresource"
+
 // MyResourceStatus defines the observed state of MyResource.
 type MyResourceStatus struct {
-    CurrentCount int    `json:"currentCount,omitempty"`
-    Phase        string `json:"phase,omitempty"`
+	// Provider is the cloud the controller resolved for the MyResource: gcp,
+	// aws or azure. Unlike spec.provider it is set even when the defaulting
+	// webhook did not run.
+	// +optional
+	Provider string `json:"provider,omitempty"`
+	// CurrentCount tracks how many instances actually exist.
+	CurrentCount int `json:"currentCount,omitempty"`
+	// ReadyCount is how many of those instances are running.
+	// +optional
+	ReadyCount int `json:"readyCount,omitempty"`
+	// Phase is a simple string to denote the state, e.g., "Creating", "Running", "Error", etc.
+	Phase string `json:"phase,omitempty"`
+
+	// ObservedGeneration is the metadata.generation the status was last computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Selector is the label selector, in string form, reported by the scale
+	// subresource. Autoscalers require one, but no pods carry the label, so
+	// only object and external metrics can drive a MyResource.
+	// +optional
+	Selector string `json:"selector,omitempty"`
+
+	// Conditions describe the current state of the MyResource.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Image is the concrete image new instances boot from. It is resolved from
+	// the spec's image family or lookup once, and again only when that reference
+	// changes, so every instance of a MyResource boots the same image.
+	// +optional
+	Image *ResolvedImage `json:"image,omitempty"`
+
+	// Instances lists the cloud instances owned by the MyResource, as last observed.
+	// +listType=map
+	// +listMapKey=id
+	// +optional
+	Instances []InstanceStatus `json:"instances,omitempty"`
+
+	// ManagedTags are the keys of the instance tags last applied from the spec,
+	// as written by the cloud. Keys dropped from the spec are removed from the
+	// instances.
+	// +listType=set
+	// +optional
+	ManagedTags []string `json:"managedTags,omitempty"`
+
+	// PendingCreates are instance creations sent to the cloud but not yet seen
+	// in its inventory. They are recorded before the request is made, so a
+	// reconcile interrupted mid-create retries them under the same name and
+	// client token and adopts the instance rather than creating another.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	PendingCreates []PendingCreate `json:"pendingCreates,omitempty"`
+}
+
+// PendingCreate records an instance creation that is in flight.
+type PendingCreate struct {
+	// Name is the name the instance is created under.
+	Name string `json:"name"`
+	// Index is the instance's ordinal.
+	Index int `json:"index"`
+	// ClientToken identifies the request to the cloud, e.g. as the EC2 client token.
+	ClientToken string `json:"clientToken"`
+	// RequestedAt is when the creation was first requested.
+	// +optional
+	RequestedAt *metav1.Time `json:"requestedAt,omitempty"`
+}
+
+// ResolvedImage records the image an image reference in the spec resolved to.
+type ResolvedImage struct {
+	// Source is the image reference from the spec, e.g. a GCE image family URL or AMI filter.
+	Source string `json:"source"`
+	// ID is the concrete image: a GCE image URL or an AMI ID.
+	ID string `json:"id"`
+}
+
+// InstanceStatus describes a single cloud instance owned by a MyResource.
+type InstanceStatus struct {
+	// Provider is the cloud the instance runs in: gcp, aws or azure.
+	Provider string `json:"provider"`
+	// ID is the provider's identifier for the instance.
+	ID string `json:"id"`
+	// Name is the instance name.
+	Name string `json:"name,omitempty"`
+	// Zone is the zone the instance runs in, or its region when it has no zone.
+	// +optional
+	Zone string `json:"zone,omitempty"`
+	// PrivateIP is the primary private IP address.
+	// +optional
+	PrivateIP string `json:"privateIP,omitempty"`
+	// PublicIP is the public IP address, if one is assigned.
+	// +optional
+	PublicIP string `json:"publicIP,omitempty"`
+	// State is the provider-neutral instance state, e.g. "Pending", "Running" or "Terminating".
+	State string `json:"state,omitempty"`
+	// CreationTime is when the cloud created the instance.
+	// +optional
+	CreationTime *metav1.Time `json:"creationTime,omitempty"`
+	// Image is the image the instance was booted from.
+	// +optional
+	Image string `json:"image,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.desiredCount,statuspath=.status.currentCount,selectorpath=.status.selector
+// +kubebuilder:resource:shortName=myres,categories=cloud
+// +kubebuilder:printcolumn:name="Provider",type=string,JSONPath=`.status.provider`
+// +kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=`.spec.desiredCount`
+// +kubebuilder:printcolumn:name="Current",type=integer,JSONPath=`.status.currentCount`
+// +kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyCount`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // MyResource is the Schema for the MyResource API.
 type MyResource struct {
-    metav1.TypeMeta   `json:",inline"`
-    metav1.ObjectMeta `json:"metadata,omitempty"`
-    Spec              MyResourceSpec   `json:"spec,omitempty"`
-    Status            MyResourceStatus `json:"status,omitempty"`
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              MyResourceSpec   `json:"spec,omitempty"`
+	Status            MyResourceStatus `json:"status,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 
 // MyResourceList contains a list of MyResource.
 type MyResourceList struct {
-    metav1.TypeMeta `json:",inline"`
-    metav1.ListMeta `json:"metadata,omitempty"`
-    Items           []MyResource `json:"items"`
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MyResource `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MyResource{}, &MyResourceList{})
 }
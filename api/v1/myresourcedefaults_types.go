@@ -0,0 +1,67 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MyResourceDefaultsName is the name of the MyResourceDefaults object the
+// defaulting webhook reads. Only one may exist.
+const MyResourceDefaultsName = "default"
+
+// Namespace annotations the defaulting webhook reads. They take precedence
+// over MyResourceDefaults and are overridden by the MyResource's own spec.
+const (
+	// DefaultProviderAnnotation names the provider for MyResources that configure none.
+	DefaultProviderAnnotation = "defaults.devops.example.com/provider"
+	// DefaultsAnnotationSuffix is appended to a provider name to form the
+	// prefix of per-field annotations. The field is the JSON name of a
+	// top-level field of that provider's config. String fields take the raw
+	// value, e.g. "gcp.defaults.devops.example.com/zone: us-central1-a", and
+	// all other fields take JSON, e.g.
+	// "aws.defaults.devops.example.com/rootVolume: '{"sizeGiB": 30}'".
+	DefaultsAnnotationSuffix = ".defaults.devops.example.com/"
+)
+
+// MyResourceDefaultsSpec holds the cluster-wide defaults for MyResources.
+type MyResourceDefaultsSpec struct {
+	// Provider is used for MyResources that neither name a provider nor configure one.
+	// +kubebuilder:validation:Enum=gcp;aws;azure
+	// +optional
+	Provider string `json:"provider,omitempty"`
+
+	// GCPConfig fills fields left unset in a MyResource's gcpConfig.
+	// +optional
+	GCPConfig *GCPConfigSpec `json:"gcpConfig,omitempty"`
+	// AWSConfig fills fields left unset in a MyResource's awsConfig.
+	// +optional
+	AWSConfig *AWSConfigSpec `json:"awsConfig,omitempty"`
+	// AzureConfig fills fields left unset in a MyResource's azureConfig.
+	// +optional
+	AzureConfig *AzureConfigSpec `json:"azureConfig,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:validation:XValidation:rule="self.metadata.name == 'default'",message="the MyResourceDefaults object must be named default"
+
+// MyResourceDefaults is the Schema for the MyResourceDefaults API. The
+// defaulting webhook fills unset provider fields of every MyResource from
+// the object named "default".
+type MyResourceDefaults struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              MyResourceDefaultsSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MyResourceDefaultsList contains a list of MyResourceDefaults.
+type MyResourceDefaultsList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MyResourceDefaults `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MyResourceDefaults{}, &MyResourceDefaultsList{})
+}
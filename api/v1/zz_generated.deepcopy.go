@@ -1,12 +1,241 @@
+//go:build !ignore_autogenerated
+
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSConfigSpec) DeepCopyInto(out *AWSConfigSpec) {
+	*out = *in
+	if in.ImageLookup != nil {
+		in, out := &in.ImageLookup, &out.ImageLookup
+		*out = new(AWSImageLookup)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AdminPasswordSecretRef != nil {
+		in, out := &in.AdminPasswordSecretRef, &out.AdminPasswordSecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImportKeyPair != nil {
+		in, out := &in.ImportKeyPair, &out.ImportKeyPair
+		*out = new(bool)
+		**out = **in
+	}
+	if in.SubnetIDs != nil {
+		in, out := &in.SubnetIDs, &out.SubnetIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SecurityGroupIDs != nil {
+		in, out := &in.SecurityGroupIDs, &out.SecurityGroupIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RootVolume != nil {
+		in, out := &in.RootVolume, &out.RootVolume
+		*out = new(AWSRootVolume)
+		**out = **in
+	}
+	if in.RequireIMDSv2 != nil {
+		in, out := &in.RequireIMDSv2, &out.RequireIMDSv2
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Placement != nil {
+		in, out := &in.Placement, &out.Placement
+		*out = new(AWSPlacement)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSConfigSpec.
+func (in *AWSConfigSpec) DeepCopy() *AWSConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSImageLookup) DeepCopyInto(out *AWSImageLookup) {
+	*out = *in
+	if in.Owners != nil {
+		in, out := &in.Owners, &out.Owners
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSImageLookup.
+func (in *AWSImageLookup) DeepCopy() *AWSImageLookup {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSImageLookup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSPlacement) DeepCopyInto(out *AWSPlacement) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSPlacement.
+func (in *AWSPlacement) DeepCopy() *AWSPlacement {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSPlacement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSRootVolume) DeepCopyInto(out *AWSRootVolume) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSRootVolume.
+func (in *AWSRootVolume) DeepCopy() *AWSRootVolume {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSRootVolume)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureConfigSpec) DeepCopyInto(out *AzureConfigSpec) {
+	*out = *in
+	if in.PublicIP != nil {
+		in, out := &in.PublicIP, &out.PublicIP
+		*out = new(bool)
+		**out = **in
+	}
+	if in.AdminPasswordSecretRef != nil {
+		in, out := &in.AdminPasswordSecretRef, &out.AdminPasswordSecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureConfigSpec.
+func (in *AzureConfigSpec) DeepCopy() *AzureConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BootstrapSpec) DeepCopyInto(out *BootstrapSpec) {
+	*out = *in
+	if in.ConfigMapRef != nil {
+		in, out := &in.ConfigMapRef, &out.ConfigMapRef
+		*out = new(corev1.ConfigMapKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.JoinTokenSecretRef != nil {
+		in, out := &in.JoinTokenSecretRef, &out.JoinTokenSecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BootstrapSpec.
+func (in *BootstrapSpec) DeepCopy() *BootstrapSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BootstrapSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCPBootDisk) DeepCopyInto(out *GCPBootDisk) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCPBootDisk.
+func (in *GCPBootDisk) DeepCopy() *GCPBootDisk {
+	if in == nil {
+		return nil
+	}
+	out := new(GCPBootDisk)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GCPConfigSpec) DeepCopyInto(out *GCPConfigSpec) {
 	*out = *in
+	if in.ExternalIP != nil {
+		in, out := &in.ExternalIP, &out.ExternalIP
+		*out = new(bool)
+		**out = **in
+	}
+	if in.NetworkTags != nil {
+		in, out := &in.NetworkTags, &out.NetworkTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ServiceAccount != nil {
+		in, out := &in.ServiceAccount, &out.ServiceAccount
+		*out = new(GCPServiceAccount)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BootDisk != nil {
+		in, out := &in.BootDisk, &out.BootDisk
+		*out = new(GCPBootDisk)
+		**out = **in
+	}
+	if in.ShieldedVM != nil {
+		in, out := &in.ShieldedVM, &out.ShieldedVM
+		*out = new(GCPShieldedVM)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCPConfigSpec.
@@ -19,13 +248,67 @@ func (in *GCPConfigSpec) DeepCopy() *GCPConfigSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCPServiceAccount) DeepCopyInto(out *GCPServiceAccount) {
+	*out = *in
+	if in.Scopes != nil {
+		in, out := &in.Scopes, &out.Scopes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCPServiceAccount.
+func (in *GCPServiceAccount) DeepCopy() *GCPServiceAccount {
+	if in == nil {
+		return nil
+	}
+	out := new(GCPServiceAccount)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCPShieldedVM) DeepCopyInto(out *GCPShieldedVM) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCPShieldedVM.
+func (in *GCPShieldedVM) DeepCopy() *GCPShieldedVM {
+	if in == nil {
+		return nil
+	}
+	out := new(GCPShieldedVM)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InstanceStatus) DeepCopyInto(out *InstanceStatus) {
+	*out = *in
+	if in.CreationTime != nil {
+		in, out := &in.CreationTime, &out.CreationTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstanceStatus.
+func (in *InstanceStatus) DeepCopy() *InstanceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(InstanceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MyResource) DeepCopyInto(out *MyResource) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MyResource.
@@ -46,6 +329,94 @@ func (in *MyResource) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MyResourceDefaults) DeepCopyInto(out *MyResourceDefaults) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MyResourceDefaults.
+func (in *MyResourceDefaults) DeepCopy() *MyResourceDefaults {
+	if in == nil {
+		return nil
+	}
+	out := new(MyResourceDefaults)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MyResourceDefaults) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MyResourceDefaultsList) DeepCopyInto(out *MyResourceDefaultsList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]MyResourceDefaults, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MyResourceDefaultsList.
+func (in *MyResourceDefaultsList) DeepCopy() *MyResourceDefaultsList {
+	if in == nil {
+		return nil
+	}
+	out := new(MyResourceDefaultsList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MyResourceDefaultsList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MyResourceDefaultsSpec) DeepCopyInto(out *MyResourceDefaultsSpec) {
+	*out = *in
+	if in.GCPConfig != nil {
+		in, out := &in.GCPConfig, &out.GCPConfig
+		*out = new(GCPConfigSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AWSConfig != nil {
+		in, out := &in.AWSConfig, &out.AWSConfig
+		*out = new(AWSConfigSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AzureConfig != nil {
+		in, out := &in.AzureConfig, &out.AzureConfig
+		*out = new(AzureConfigSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MyResourceDefaultsSpec.
+func (in *MyResourceDefaultsSpec) DeepCopy() *MyResourceDefaultsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MyResourceDefaultsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MyResourceList) DeepCopyInto(out *MyResourceList) {
 	*out = *in
@@ -84,7 +455,39 @@ func (in *MyResourceSpec) DeepCopyInto(out *MyResourceSpec) {
 	if in.GCPConfig != nil {
 		in, out := &in.GCPConfig, &out.GCPConfig
 		*out = new(GCPConfigSpec)
-		**out = **in
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AWSConfig != nil {
+		in, out := &in.AWSConfig, &out.AWSConfig
+		*out = new(AWSConfigSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AzureConfig != nil {
+		in, out := &in.AzureConfig, &out.AzureConfig
+		*out = new(AzureConfigSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SSH != nil {
+		in, out := &in.SSH, &out.SSH
+		*out = new(SSHSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Bootstrap != nil {
+		in, out := &in.Bootstrap, &out.Bootstrap
+		*out = new(BootstrapSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.PropagateLabels != nil {
+		in, out := &in.PropagateLabels, &out.PropagateLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
 	}
 }
 
@@ -101,6 +504,37 @@ func (in *MyResourceSpec) DeepCopy() *MyResourceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MyResourceStatus) DeepCopyInto(out *MyResourceStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Image != nil {
+		in, out := &in.Image, &out.Image
+		*out = new(ResolvedImage)
+		**out = **in
+	}
+	if in.Instances != nil {
+		in, out := &in.Instances, &out.Instances
+		*out = make([]InstanceStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ManagedTags != nil {
+		in, out := &in.ManagedTags, &out.ManagedTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PendingCreates != nil {
+		in, out := &in.PendingCreates, &out.PendingCreates
+		*out = make([]PendingCreate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MyResourceStatus.
@@ -112,3 +546,62 @@ func (in *MyResourceStatus) DeepCopy() *MyResourceStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PendingCreate) DeepCopyInto(out *PendingCreate) {
+	*out = *in
+	if in.RequestedAt != nil {
+		in, out := &in.RequestedAt, &out.RequestedAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PendingCreate.
+func (in *PendingCreate) DeepCopy() *PendingCreate {
+	if in == nil {
+		return nil
+	}
+	out := new(PendingCreate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResolvedImage) DeepCopyInto(out *ResolvedImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResolvedImage.
+func (in *ResolvedImage) DeepCopy() *ResolvedImage {
+	if in == nil {
+		return nil
+	}
+	out := new(ResolvedImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SSHSpec) DeepCopyInto(out *SSHSpec) {
+	*out = *in
+	if in.PublicKeys != nil {
+		in, out := &in.PublicKeys, &out.PublicKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PublicKeysSecretRef != nil {
+		in, out := &in.PublicKeysSecretRef, &out.PublicKeysSecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SSHSpec.
+func (in *SSHSpec) DeepCopy() *SSHSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SSHSpec)
+	in.DeepCopyInto(out)
+	return out
+}
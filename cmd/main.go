@@ -19,7 +19,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
-	controllers "k8s-custom-controller/internal/controller"
+	controllers "github.com/andyzhang8/k8s-custom-controller/internal/controller"
+	webhookdevopsv1 "github.com/andyzhang8/k8s-custom-controller/internal/webhook/v1"
+	"github.com/andyzhang8/k8s-custom-controller/pkg/cloudclients"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -102,12 +104,20 @@ func main() {
 	}
 
 	if err = (&controllers.MyResourceReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Providers: cloudclients.NewDefaultRegistry(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "MyResource")
 		os.Exit(1)
 	}
+	// nolint:goconst
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = webhookdevopsv1.SetupMyResourceWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "MyResource")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
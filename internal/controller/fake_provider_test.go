@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+	"github.com/andyzhang8/k8s-custom-controller/pkg/cloudclients"
+)
+
+// fakeProvider is an in-memory cloudclients.Provider used by the controller tests.
+type fakeProvider struct {
+	mu        sync.Mutex
+	name      string
+	nextID    int
+	owner     cloudclients.Owner
+	instances []cloudclients.Instance
+	// creds are the credentials the provider was last built with.
+	creds cloudclients.Credentials
+	// lastRequest is the request passed to the last CreateInstance call.
+	lastRequest cloudclients.InstanceRequest
+	// imageResolves counts ResolveImage calls.
+	imageResolves int
+	// cleanups counts Cleanup calls.
+	cleanups int
+
+	// unlisted hides instances from ListInstances, like EC2 before its
+	// inventory catches up with a launch. CreateInstance reveals them.
+	unlisted map[string]bool
+
+	// asyncDelete leaves deleted instances in the Terminating state until
+	// finishTerminations is called, like EC2 does.
+	asyncDelete bool
+
+	// listErr, when set, is returned by ListInstances.
+	listErr error
+}
+
+func (p *fakeProvider) Name() string {
+	return p.name
+}
+
+func (p *fakeProvider) ListInstances(_ context.Context) ([]cloudclients.Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listErr != nil {
+		return nil, p.listErr
+	}
+	var owned []cloudclients.Instance
+	for _, instance := range p.instances {
+		if instance.Tags[cloudclients.OwnerUIDTag] == p.owner.UID && !p.unlisted[instance.ID] {
+			owned = append(owned, instance)
+		}
+	}
+	return owned, nil
+}
+
+func (p *fakeProvider) ListOrphans(_ context.Context) ([]cloudclients.Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var orphans []cloudclients.Instance
+	for _, instance := range p.instances {
+		_, claimed := instance.Tags[cloudclients.OwnerUIDTag]
+		if !claimed &&
+			instance.Tags[cloudclients.OwnerNamespaceTag] == p.owner.Namespace &&
+			instance.Tags[cloudclients.OwnerNameTag] == p.owner.Name {
+			orphans = append(orphans, instance)
+		}
+	}
+	return orphans, nil
+}
+
+func (p *fakeProvider) UpdateTags(_ context.Context, id string, set map[string]string, remove []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.instances {
+		if p.instances[i].ID != id {
+			continue
+		}
+		tags := make(map[string]string)
+		for key, value := range p.instances[i].Tags {
+			tags[key] = value
+		}
+		for key, value := range set {
+			tags[key] = value
+		}
+		for _, key := range remove {
+			delete(tags, key)
+		}
+		p.instances[i].Tags = tags
+		return nil
+	}
+	return fmt.Errorf("instance %s not found", id)
+}
+
+func (p *fakeProvider) CreateInstance(_ context.Context, req cloudclients.InstanceRequest) (*cloudclients.Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastRequest = req
+	for _, instance := range p.instances {
+		if instance.Name == req.Name {
+			delete(p.unlisted, instance.ID)
+			return &instance, nil
+		}
+	}
+	p.nextID++
+	tags := p.owner.Tags()
+	for key, value := range req.Tags {
+		if _, owner := tags[key]; !owner {
+			tags[key] = value
+		}
+	}
+	instance := cloudclients.Instance{
+		ID:        fmt.Sprintf("fake-%d", p.nextID),
+		Name:      req.Name,
+		State:     cloudclients.InstanceRunning,
+		Tags:      tags,
+		Zone:      "us-central1-a",
+		PrivateIP: fmt.Sprintf("10.0.0.%d", p.nextID),
+		CreatedAt: time.Now(),
+		Image:     req.Image,
+	}
+	p.instances = append(p.instances, instance)
+	return &instance, nil
+}
+
+func (p *fakeProvider) DeleteInstance(_ context.Context, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, instance := range p.instances {
+		if instance.ID == id {
+			if p.asyncDelete {
+				p.instances[i].State = cloudclients.InstanceTerminating
+			} else {
+				p.instances = append(p.instances[:i], p.instances[i+1:]...)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("instance %s not found", id)
+}
+
+func (p *fakeProvider) ImageSource() string {
+	return "projects/test-images/global/images/family/test"
+}
+
+// ResolveImage returns a new image on every call, like a family receiving updates.
+func (p *fakeProvider) ResolveImage(_ context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.imageResolves++
+	return fmt.Sprintf("projects/test-images/global/images/test-v%d", p.imageResolves), nil
+}
+
+func (p *fakeProvider) Cleanup(_ context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cleanups++
+	return nil
+}
+
+// finishTerminations removes every instance that is being terminated.
+func (p *fakeProvider) finishTerminations() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var remaining []cloudclients.Instance
+	for _, instance := range p.instances {
+		if instance.State != cloudclients.InstanceTerminating {
+			remaining = append(remaining, instance)
+		}
+	}
+	p.instances = remaining
+}
+
+func (p *fakeProvider) DescribeInstance(_ context.Context, id string) (*cloudclients.Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, instance := range p.instances {
+		if instance.ID == id {
+			return &instance, nil
+		}
+	}
+	return nil, fmt.Errorf("instance %s not found", id)
+}
+
+// newFakeRegistry returns a registry whose GCP and Azure providers are replaced by fake.
+func newFakeRegistry(fake *fakeProvider) *cloudclients.Registry {
+	registry := cloudclients.NewDefaultRegistry()
+	factory := func(name string) cloudclients.Factory {
+		return func(_ context.Context, res *devopsv1.MyResource, creds cloudclients.Credentials) (cloudclients.Provider, error) {
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			fake.name = name
+			fake.owner = cloudclients.OwnerOf(res)
+			fake.creds = creds
+			return fake, nil
+		}
+	}
+	registry.Register(cloudclients.ProviderGCP,
+		func(spec *devopsv1.MyResourceSpec) bool { return spec.GCPConfig != nil },
+		factory(cloudclients.ProviderGCP))
+	registry.Register(cloudclients.ProviderAzure,
+		func(spec *devopsv1.MyResourceSpec) bool { return spec.AzureConfig != nil },
+		factory(cloudclients.ProviderAzure))
+	return registry
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+)
+
+// errInvalidBootstrap marks bootstrap templates that fail to parse or render.
+var errInvalidBootstrap = errors.New("invalid bootstrap template")
+
+// bootstrapVars are the variables available to bootstrap templates. Keep the
+// list in the BootstrapSpec.Template documentation in sync.
+type bootstrapVars struct {
+	Index     int
+	Name      string
+	Namespace string
+	Provider  string
+	Region    string
+	Zone      string
+	JoinToken string
+}
+
+// bootstrapTemplate renders spec.bootstrap for individual instances.
+type bootstrapTemplate struct {
+	tmpl *template.Template
+	vars bootstrapVars
+}
+
+// parseBootstrapTemplate parses payload and renders it once, so template
+// errors surface before any instance is created.
+func parseBootstrapTemplate(payload string, vars bootstrapVars) (*bootstrapTemplate, error) {
+	tmpl, err := template.New("bootstrap").Option("missingkey=error").Parse(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidBootstrap, err)
+	}
+	b := &bootstrapTemplate{tmpl: tmpl, vars: vars}
+	if _, err := b.render(0); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// render returns the user data for the instance with the given index.
+func (b *bootstrapTemplate) render(index int) (string, error) {
+	vars := b.vars
+	vars.Index = index
+
+	var buf bytes.Buffer
+	if err := b.tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("%w: %w", errInvalidBootstrap, err)
+	}
+	return buf.String(), nil
+}
+
+// specLocation returns the region and zone of whichever provider the spec configures.
+func specLocation(spec *devopsv1.MyResourceSpec) (string, string) {
+	switch {
+	case spec.GCPConfig != nil:
+		region, zone := spec.GCPConfig.Region, spec.GCPConfig.Zone
+		// A GCE zone is its region plus a suffix, e.g. us-central1-a.
+		if i := strings.LastIndex(zone, "-"); region == "" && i > 0 {
+			region = zone[:i]
+		}
+		return region, zone
+	case spec.AWSConfig != nil && spec.AWSConfig.Placement != nil:
+		return spec.AWSConfig.Region, spec.AWSConfig.Placement.AvailabilityZone
+	case spec.AWSConfig != nil:
+		return spec.AWSConfig.Region, ""
+	case spec.AzureConfig != nil:
+		return spec.AzureConfig.Region, ""
+	default:
+		return "", ""
+	}
+}
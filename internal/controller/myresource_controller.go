@@ -1,168 +1,710 @@
 package controllers
 
 import (
-    "context"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
-    "k8s.io/apimachinery/pkg/api/errors"
-    "k8s.io/apimachinery/pkg/runtime"
-    "k8s.io/apimachinery/pkg/util/validation/field"
-    ctrl "sigs.k8s.io/controller-runtime"
-    "sigs.k8s.io/controller-runtime/pkg/client"
-    "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
-    devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
-    "k8s-custom-controller/pkg/cloudclients"
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+	"github.com/andyzhang8/k8s-custom-controller/pkg/cloudclients"
 )
 
 // MyResourceReconciler reconciles a MyResource object
 type MyResourceReconciler struct {
-    client.Client
-    Scheme *runtime.Scheme
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Providers resolves the cloud provider for a MyResource. Defaults to
+	// cloudclients.NewDefaultRegistry() when nil.
+	Providers *cloudclients.Registry
+
+	// APIReader reads Secrets and ConfigMaps straight from the API server, so
+	// their contents are never cached. Defaults to the manager's API reader,
+	// or to Client when the reconciler is not set up with a manager.
+	APIReader client.Reader
+
+	cache providerCache
 }
 
 const myResourceFinalizer = "myresource.devops.example.com/finalizer"
 
+// secretRefIndex indexes MyResources by the names of the Secrets their spec references.
+const secretRefIndex = ".spec.secretRefs"
+
+const (
+	// inventoryResyncInterval is how often a converged MyResource is checked
+	// against the cloud to catch instances removed or added out of band.
+	inventoryResyncInterval = 5 * time.Minute
+	// settlingRequeueInterval is used while instances are still starting or stopping.
+	settlingRequeueInterval = 30 * time.Second
+)
+
 // +kubebuilder:rbac:groups=devops.example.com,resources=myresources,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=devops.example.com,resources=myresources/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=devops.example.com,resources=myresources/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get
 
 func (r *MyResourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-    log := ctrl.Log.WithName("controller").WithValues("myresource", req.NamespacedName)
-    log.Info("Starting reconcile loop")
-
-    var myResource devopsv1.MyResource
-    if err := r.Get(ctx, req.NamespacedName, &myResource); err != nil {
-        if errors.IsNotFound(err) {
-            log.Info("MyResource not found; might have been deleted")
-            return ctrl.Result{}, nil
-        }
-        // Error reading the object - requeue the request.
-        log.Error(err, "Failed to get MyResource")
-        return ctrl.Result{}, err
-    }
-
-    if myResource.GetDeletionTimestamp().IsZero() {
-        if !controllerutil.ContainsFinalizer(&myResource, myResourceFinalizer) {
-            log.Info("Adding finalizer to MyResource")
-            controllerutil.AddFinalizer(&myResource, myResourceFinalizer)
-            if err := r.Update(ctx, &myResource); err != nil {
-                log.Error(err, "Failed to add finalizer")
-                return ctrl.Result{}, err
-            }
-            return ctrl.Result{Requeue: true}, nil
-        }
-    } else {
-        // The object IS being deleted
-        if controllerutil.ContainsFinalizer(&myResource, myResourceFinalizer) {
-            log.Info("Finalizing MyResource; perform external cleanup if needed")
-
-            // Remove the finalizer to allow deletion to proceed
-            controllerutil.RemoveFinalizer(&myResource, myResourceFinalizer)
-            if err := r.Update(ctx, &myResource); err != nil {
-                log.Error(err, "Failed to remove finalizer")
-                return ctrl.Result{}, err
-            }
-        }
-        log.Info("MyResource is being deleted; reconciliation complete")
-        return ctrl.Result{}, nil
-    }
-
-    // 3. Validate Spec
-    if err := r.validateSpec(&myResource); err != nil {
-        log.Error(err, "Spec validation failed")
-        myResource.Status.Phase = "Error"
-        _ = r.Status().Update(ctx, &myResource)
-        return ctrl.Result{}, err
-    }
-
-    desiredCount := myResource.Spec.DesiredCount
-    currentCount := myResource.Status.CurrentCount
-
-    if myResource.Spec.GCPConfig != nil {
-        log.Info("Using GCP configuration")
-        err := cloudclients.UpdateGCPInstances(
-            ctx,
-            *myResource.Spec.GCPConfig,
-            currentCount,
-            desiredCount,
-        )
-        if err != nil {
-            log.Error(err, "Failed to update GCP instances")
-            myResource.Status.Phase = "Error"
-            _ = r.Status().Update(ctx, &myResource)
-            return ctrl.Result{}, err
-        }
-    } else if myResource.Spec.AWSConfig != nil {
-        log.Info("Using AWS configuration")
-        err := cloudclients.UpdateAWSInstances(
-            ctx,
-            *myResource.Spec.AWSConfig,
-            currentCount,
-            desiredCount,
-        )
-        if err != nil {
-            log.Error(err, "Failed to update AWS instances")
-            myResource.Status.Phase = "Error"
-            _ = r.Status().Update(ctx, &myResource)
-            return ctrl.Result{}, err
-        }
-    } else if myResource.Spec.AzureConfig != nil {
-        log.Info("Using Azure configuration")
-        err := cloudclients.UpdateAzureInstances(
-            ctx,
-            *myResource.Spec.AzureConfig,
-            currentCount,
-            desiredCount,
-        )
-        if err != nil {
-            log.Error(err, "Failed to update Azure instances")
-            myResource.Status.Phase = "Error"
-            _ = r.Status().Update(ctx, &myResource)
-            return ctrl.Result{}, err
-        }
-    } else {
-        log.Info("No cloud configuration found; skipping provisioning.")
-        return ctrl.Result{}, nil
-    }
-
-    myResource.Status.CurrentCount = desiredCount
-    if desiredCount > currentCount {
-        myResource.Status.Phase = "ScaledUp"
-    } else {
-        myResource.Status.Phase = "ScaledDown"
-    }
-
-    if err := r.Status().Update(ctx, &myResource); err != nil {
-        log.Error(err, "Failed to update MyResource status")
-        return ctrl.Result{}, err
-    }
-
-    log.Info("Provisioning action succeeded",
-        "currentCount", myResource.Status.CurrentCount,
-        "phase", myResource.Status.Phase)
-
-    // Return without requeue
-    log.Info("Reconciliation complete")
-    return ctrl.Result{}, nil
+	log := ctrl.Log.WithName("controller").WithValues("myresource", req.NamespacedName)
+	log.Info("Starting reconcile loop")
+
+	var myResource devopsv1.MyResource
+	if err := r.Get(ctx, req.NamespacedName, &myResource); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("MyResource not found; might have been deleted")
+			r.cache.invalidate(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get MyResource")
+		return ctrl.Result{}, err
+	}
+
+	if myResource.GetDeletionTimestamp().IsZero() {
+		if !controllerutil.ContainsFinalizer(&myResource, myResourceFinalizer) {
+			log.Info("Adding finalizer to MyResource")
+			controllerutil.AddFinalizer(&myResource, myResourceFinalizer)
+			if err := r.Update(ctx, &myResource); err != nil {
+				log.Error(err, "Failed to add finalizer")
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{Requeue: true}, nil
+		}
+	} else {
+		// The object IS being deleted
+		if controllerutil.ContainsFinalizer(&myResource, myResourceFinalizer) {
+			log.Info("Finalizing MyResource; tearing down cloud instances")
+
+			providerName, remaining, err := r.finalizeInstances(ctx, &myResource)
+			if apierrors.IsNotFound(err) {
+				// Retrying cannot bring the Secret back, and the Secret watch
+				// requeues the MyResource if someone restores it.
+				message := fmt.Sprintf("cannot delete cloud instances: %v; restore the Secret, "+
+					"or remove the %s finalizer to leave the instances running", err, myResourceFinalizer)
+				log.Info("Credentials Secret is gone; waiting before deleting cloud instances", "reason", message)
+				markFailed(&myResource, reasonCredentialsMissing, message)
+				setCondition(&myResource, devopsv1.ConditionCredentialsValid, metav1.ConditionFalse,
+					reasonCredentialsMissing, err.Error())
+				setCondition(&myResource, devopsv1.ConditionDeleting, metav1.ConditionTrue, reasonCredentialsMissing, message)
+				if err := r.updateStatus(ctx, &myResource); err != nil {
+					log.Error(err, "Failed to update MyResource status")
+					return ctrl.Result{}, err
+				}
+				return ctrl.Result{}, nil
+			}
+			if err != nil {
+				log.Error(err, "Failed to tear down cloud instances")
+				if cloudclients.IsCredentialsError(err) {
+					setCondition(&myResource, devopsv1.ConditionCredentialsValid, metav1.ConditionFalse,
+						reasonInvalidCredentials, err.Error())
+				}
+				markFailed(&myResource, reasonFinalizeFailed, err.Error())
+				setCondition(&myResource, devopsv1.ConditionDeleting, metav1.ConditionTrue, reasonFinalizeFailed, err.Error())
+				_ = r.updateStatus(ctx, &myResource)
+				return ctrl.Result{}, err
+			}
+			if len(remaining) > 0 {
+				log.Info("Waiting for cloud instances to terminate", "remaining", len(remaining))
+				message := fmt.Sprintf("waiting for %d instance(s) to terminate", len(remaining))
+				myResource.Status.Phase = "Deleting"
+				myResource.Status.CurrentCount = len(remaining)
+				myResource.Status.ReadyCount = readyCount(remaining)
+				myResource.Status.Instances = instanceStatuses(providerName, remaining)
+				setCondition(&myResource, devopsv1.ConditionDeleting, metav1.ConditionTrue, reasonFinalizing, message)
+				setCondition(&myResource, devopsv1.ConditionReady, metav1.ConditionFalse, reasonFinalizing, message)
+				setCondition(&myResource, devopsv1.ConditionProgressing, metav1.ConditionTrue, reasonFinalizing, message)
+				setCondition(&myResource, devopsv1.ConditionDegraded, metav1.ConditionFalse, reasonFinalizing, message)
+				if err := r.updateStatus(ctx, &myResource); err != nil {
+					log.Error(err, "Failed to update MyResource status")
+					return ctrl.Result{}, err
+				}
+				return ctrl.Result{RequeueAfter: settlingRequeueInterval}, nil
+			}
+
+			// Remove the finalizer to allow deletion to proceed
+			controllerutil.RemoveFinalizer(&myResource, myResourceFinalizer)
+			if err := r.Update(ctx, &myResource); err != nil {
+				log.Error(err, "Failed to remove finalizer")
+				return ctrl.Result{}, err
+			}
+			r.cache.invalidate(req.NamespacedName)
+		}
+		log.Info("MyResource is being deleted; reconciliation complete")
+		return ctrl.Result{}, nil
+	}
+
+	// 3. Validate Spec
+	if err := r.validateSpec(&myResource); err != nil {
+		log.Error(err, "Spec validation failed")
+		markFailed(&myResource, reasonInvalidSpec, err.Error())
+		_ = r.updateStatus(ctx, &myResource)
+		// Retrying cannot fix the spec; the next edit triggers a new reconcile.
+		return ctrl.Result{}, reconcile.TerminalError(err)
+	}
+
+	provider, err := r.resolveProvider(ctx, &myResource)
+	if errors.Is(err, cloudclients.ErrNoProvider) {
+		log.Info("No cloud configuration found; skipping provisioning.")
+		message := "no cloud configuration found in spec"
+		setCondition(&myResource, devopsv1.ConditionReady, metav1.ConditionFalse, reasonNoProvider, message)
+		setCondition(&myResource, devopsv1.ConditionProgressing, metav1.ConditionFalse, reasonNoProvider, message)
+		setCondition(&myResource, devopsv1.ConditionDegraded, metav1.ConditionFalse, reasonNoProvider, message)
+		if err := r.updateStatus(ctx, &myResource); err != nil {
+			log.Error(err, "Failed to update MyResource status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		log.Error(err, "Failed to resolve cloud provider")
+		// Only credential problems say anything about CredentialsValid; a failed
+		// Secret read or an unusable config is reported through Ready and Degraded.
+		reason := reasonProviderInitFailed
+		if cloudclients.IsCredentialsError(err) {
+			reason = reasonInvalidCredentials
+			setCondition(&myResource, devopsv1.ConditionCredentialsValid, metav1.ConditionFalse, reason, err.Error())
+		}
+		markFailed(&myResource, reason, err.Error())
+		_ = r.updateStatus(ctx, &myResource)
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Using cloud provider", "provider", provider.Name())
+	myResource.Status.Provider = provider.Name()
+	result, err := r.convergeInstances(ctx, provider, &myResource)
+	// CurrentCount always reflects what the cloud reports, even after a partial failure.
+	myResource.Status.CurrentCount = len(result.instances)
+	myResource.Status.ReadyCount = readyCount(result.instances)
+	if result.listed {
+		myResource.Status.Instances = instanceStatuses(provider.Name(), result.instances)
+		setCondition(&myResource, devopsv1.ConditionCredentialsValid, metav1.ConditionTrue, reasonCloudAPIReachable,
+			fmt.Sprintf("listed instances through the %s API", provider.Name()))
+	}
+	if err != nil {
+		log.Error(err, "Failed to update instances", "provider", provider.Name())
+		reason := reasonProvisioningFailed
+		switch {
+		case errors.Is(err, errInvalidBootstrap):
+			reason = reasonInvalidBootstrap
+		case errors.Is(err, errInvalidNameTemplate):
+			reason = reasonInvalidSpec
+		case cloudclients.IsCredentialsError(err):
+			reason = reasonInvalidCredentials
+			setCondition(&myResource, devopsv1.ConditionCredentialsValid, metav1.ConditionFalse, reason, err.Error())
+		}
+		markFailed(&myResource, reason, err.Error())
+		setCondition(&myResource, devopsv1.ConditionProgressing, metav1.ConditionTrue, reason,
+			"retrying after provisioning failure")
+		_ = r.updateStatus(ctx, &myResource)
+		return ctrl.Result{}, err
+	}
+
+	switch {
+	case result.created > 0:
+		myResource.Status.Phase = "ScaledUp"
+	case result.deleted > 0:
+		myResource.Status.Phase = "ScaledDown"
+	default:
+		myResource.Status.Phase = "Running"
+	}
+	setConvergedConditions(&myResource, result)
+
+	if err := r.updateStatus(ctx, &myResource); err != nil {
+		log.Error(err, "Failed to update MyResource status")
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Provisioning action succeeded",
+		"currentCount", myResource.Status.CurrentCount,
+		"adopted", result.adopted,
+		"created", result.created,
+		"deleted", result.deleted,
+		"phase", myResource.Status.Phase)
+
+	// Instances can change outside the controller, so keep polling the cloud.
+	log.Info("Reconciliation complete")
+	if result.settling {
+		return ctrl.Result{RequeueAfter: settlingRequeueInterval}, nil
+	}
+	return ctrl.Result{RequeueAfter: inventoryResyncInterval}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *MyResourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-    r.Scheme = mgr.GetScheme()
+	r.Scheme = mgr.GetScheme()
+	if r.Providers == nil {
+		r.Providers = cloudclients.NewDefaultRegistry()
+	}
+	if r.APIReader == nil {
+		r.APIReader = mgr.GetAPIReader()
+	}
+
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &devopsv1.MyResource{}, secretRefIndex,
+		func(obj client.Object) []string {
+			return referencedSecrets(obj.(*devopsv1.MyResource))
+		})
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&devopsv1.MyResource{}).
+		// Rotated or newly created credentials take effect without touching the
+		// MyResource. Only metadata is watched; the data is read when needed.
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.myResourcesForSecret),
+			builder.OnlyMetadata).
+		Complete(r)
+}
+
+// myResourcesForSecret maps a Secret to the MyResources referencing it and
+// drops their cached providers so the next reconcile reads the Secret again.
+func (r *MyResourceReconciler) myResourcesForSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	log := ctrl.Log.WithName("controller").WithValues("secret", client.ObjectKeyFromObject(secret))
+
+	var list devopsv1.MyResourceList
+	err := r.List(ctx, &list,
+		client.InNamespace(secret.GetNamespace()),
+		client.MatchingFields{secretRefIndex: secret.GetName()})
+	if err != nil {
+		log.Error(err, "Failed to list MyResources referencing Secret")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, myRes := range list.Items {
+		key := client.ObjectKeyFromObject(&myRes)
+		r.cache.invalidate(key)
+		requests = append(requests, reconcile.Request{NamespacedName: key})
+	}
+	return requests
+}
+
+// referencedSecrets returns the names of the Secrets the spec references.
+func referencedSecrets(myRes *devopsv1.MyResource) []string {
+	var names []string
+	if name := cloudclients.CredentialsSecretName(&myRes.Spec); name != "" {
+		names = append(names, name)
+	}
+	if aws := myRes.Spec.AWSConfig; aws != nil && aws.AdminPasswordSecretRef != nil {
+		names = append(names, aws.AdminPasswordSecretRef.Name)
+	}
+	if azure := myRes.Spec.AzureConfig; azure != nil && azure.AdminPasswordSecretRef != nil {
+		names = append(names, azure.AdminPasswordSecretRef.Name)
+	}
+	if bootstrap := myRes.Spec.Bootstrap; bootstrap != nil && bootstrap.SecretRef != nil {
+		names = append(names, bootstrap.SecretRef.Name)
+	}
+	if bootstrap := myRes.Spec.Bootstrap; bootstrap != nil && bootstrap.JoinTokenSecretRef != nil {
+		names = append(names, bootstrap.JoinTokenSecretRef.Name)
+	}
+	if ssh := myRes.Spec.SSH; ssh != nil && ssh.PublicKeysSecretRef != nil {
+		names = append(names, ssh.PublicKeysSecretRef.Name)
+	}
+	return names
+}
+
+// updateStatus writes the status subresource, stamping the generation it was
+// computed for and the scale selector. The spec may have changed since myRes
+// was read, e.g. through the scale subresource; the status is then written on
+// top of the latest object, still stamped with the generation it reflects, and
+// the change triggers another reconcile.
+func (r *MyResourceReconciler) updateStatus(ctx context.Context, myRes *devopsv1.MyResource) error {
+	myRes.Status.ObservedGeneration = myRes.Generation
+	myRes.Status.Selector = labels.Set{devopsv1.MyResourceLabel: myRes.Name}.String()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := r.Status().Update(ctx, myRes)
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+		var latest devopsv1.MyResource
+		if err := r.Get(ctx, client.ObjectKeyFromObject(myRes), &latest); err != nil {
+			return err
+		}
+		myRes.ResourceVersion = latest.ResourceVersion
+		return err
+	})
+}
+
+// recordPendingCreate adds req to status.pendingCreates and persists the list
+// before the instance is requested, leaving the rest of the status to the
+// next updateStatus.
+func (r *MyResourceReconciler) recordPendingCreate(ctx context.Context, myRes *devopsv1.MyResource, req cloudclients.InstanceRequest) error {
+	now := metav1.Now()
+	myRes.Status.PendingCreates = append(myRes.Status.PendingCreates, devopsv1.PendingCreate{
+		Name:        req.Name,
+		Index:       req.Index,
+		ClientToken: req.ClientToken,
+		RequestedAt: &now,
+	})
+
+	patch, err := json.Marshal(map[string]any{
+		"status": map[string]any{"pendingCreates": myRes.Status.PendingCreates},
+	})
+	if err != nil {
+		return err
+	}
+	// Patch a copy: the response would overwrite status fields not yet written.
+	patched := myRes.DeepCopy()
+	if err := r.Status().Patch(ctx, patched, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		return fmt.Errorf("failed to record pending creation of %s: %w", req.Name, err)
+	}
+	myRes.ResourceVersion = patched.ResourceVersion
+	return nil
+}
+
+// removePendingCreate drops the pending creation with the given name from the status.
+func removePendingCreate(myRes *devopsv1.MyResource, name string) {
+	var pending []devopsv1.PendingCreate
+	for _, create := range myRes.Status.PendingCreates {
+		if create.Name != name {
+			pending = append(pending, create)
+		}
+	}
+	myRes.Status.PendingCreates = pending
+}
 
-    return ctrl.NewControllerManagedBy(mgr).
-        For(&devopsv1.MyResource{}).
-        Complete(r)
+// reader returns the reader for Secrets and ConfigMaps.
+func (r *MyResourceReconciler) reader() client.Reader {
+	if r.APIReader == nil {
+		return r.Client
+	}
+	return r.APIReader
+}
+
+func (r *MyResourceReconciler) providers() *cloudclients.Registry {
+	if r.Providers == nil {
+		r.Providers = cloudclients.NewDefaultRegistry()
+	}
+	return r.Providers
+}
+
+// resolveProvider returns the provider selected by the spec, authenticated with
+// the credentials Secret it references, if any. Providers are cached until the
+// spec or a referenced Secret changes.
+func (r *MyResourceReconciler) resolveProvider(ctx context.Context, myRes *devopsv1.MyResource) (cloudclients.Provider, error) {
+	if provider, ok := r.cache.get(myRes); ok {
+		return provider, nil
+	}
+
+	creds, err := r.credentials(ctx, myRes)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := r.providers().Resolve(ctx, myRes, creds)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.put(myRes, provider)
+	return provider, nil
+}
+
+// credentials returns the data of the credentials Secret referenced by the
+// spec, or nil if none is referenced.
+func (r *MyResourceReconciler) credentials(ctx context.Context, myRes *devopsv1.MyResource) (cloudclients.Credentials, error) {
+	name := cloudclients.CredentialsSecretName(&myRes.Spec)
+	if name == "" {
+		return nil, nil
+	}
+
+	var secret corev1.Secret
+	if err := r.reader().Get(ctx, client.ObjectKey{Namespace: myRes.Namespace, Name: name}, &secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("credentials secret %q: %w: %w", name, cloudclients.ErrInvalidCredentials, err)
+		}
+		return nil, fmt.Errorf("failed to get credentials secret %q: %w", name, err)
+	}
+
+	// Copy into a non-nil map so an empty Secret is not mistaken for "no Secret".
+	creds := make(cloudclients.Credentials, len(secret.Data))
+	for key, value := range secret.Data {
+		creds[key] = value
+	}
+	return creds, nil
+}
+
+// convergeResult summarises one pass of convergeInstances.
+type convergeResult struct {
+	// listed is true once the provider answered a list call.
+	listed bool
+	// instances are the active instances left after the pass.
+	instances []cloudclients.Instance
+	adopted   int
+	created   int
+	deleted   int
+	// settling is true while instances are still starting or shutting down.
+	settling bool
+}
+
+// convergeInstances lists the instances the provider actually has for the
+// MyResource, adopts orphans left by an earlier MyResource of the same name,
+// brings their tags in line with the spec, and creates or deletes instances
+// until spec.desiredCount are active.
+// The returned result reflects every successful action, even when err is set.
+func (r *MyResourceReconciler) convergeInstances(
+	ctx context.Context,
+	provider cloudclients.Provider,
+	myRes *devopsv1.MyResource,
+) (convergeResult, error) {
+	var result convergeResult
+	desiredCount := myRes.Spec.DesiredCount
+
+	reqs, err := r.newInstanceRequests(ctx, provider, myRes)
+	if err != nil {
+		return result, err
+	}
+
+	observed, err := provider.ListInstances(ctx)
+	if err != nil {
+		return result, err
+	}
+	result.listed = true
+
+	orphans, err := provider.ListOrphans(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, orphan := range orphans {
+		if err := provider.UpdateTags(ctx, orphan.ID, cloudclients.OwnerOf(myRes).Tags(), nil); err != nil {
+			return result, err
+		}
+		observed = append(observed, orphan)
+		result.adopted++
+	}
+	for _, instance := range observed {
+		if !instance.Active() {
+			// Terminations still in flight are already on their way out.
+			result.settling = true
+			continue
+		}
+		if instance.State != cloudclients.InstanceRunning {
+			result.settling = true
+		}
+		result.instances = append(result.instances, instance)
+	}
+
+	// Put back tags changed out of band and apply spec changes to existing instances.
+	managed, err := reconcileTags(ctx, provider, result.instances, reqs.base.Tags, myRes.Status.ManagedTags)
+	if err != nil {
+		return result, err
+	}
+	myRes.Status.ManagedTags = managed
+
+	// Instances still terminating hold on to their names too.
+	used := make(map[string]bool, len(observed))
+	for _, instance := range observed {
+		used[instance.Name] = true
+	}
+	// Creations the inventory confirms are done; the rest are retried below.
+	var pending []devopsv1.PendingCreate
+	for _, create := range myRes.Status.PendingCreates {
+		if !used[create.Name] {
+			pending = append(pending, create)
+			used[create.Name] = true
+		}
+	}
+	myRes.Status.PendingCreates = pending
+
+	if len(result.instances) < desiredCount {
+		if err := r.resolveInstanceRequests(ctx, provider, myRes, &reqs); err != nil {
+			return result, err
+		}
+		for len(result.instances) < desiredCount {
+			var req cloudclients.InstanceRequest
+			if len(pending) > 0 {
+				req, err = reqs.forCreate(pending[0])
+				pending = pending[1:]
+			} else {
+				req, err = reqs.next(used)
+				if err == nil {
+					err = r.recordPendingCreate(ctx, myRes, req)
+				}
+			}
+			if err != nil {
+				return result, err
+			}
+			instance, err := provider.CreateInstance(ctx, req)
+			if err != nil {
+				return result, err
+			}
+			removePendingCreate(myRes, req.Name)
+			result.instances = append(result.instances, *instance)
+			result.created++
+			// Look again soon to confirm the new instance comes up.
+			result.settling = true
+		}
+	}
+
+	// Enough instances exist without the creations left over; should any of
+	// them still appear, it is scaled down like any other excess instance.
+	for _, create := range pending {
+		removePendingCreate(myRes, create.Name)
+	}
+
+	if excess := len(result.instances) - desiredCount; excess > 0 {
+		sortForDeletion(result.instances, reqs.namer)
+		for excess > 0 {
+			if err := provider.DeleteInstance(ctx, result.instances[0].ID); err != nil {
+				return result, err
+			}
+			result.instances = result.instances[1:]
+			result.deleted++
+			result.settling = true
+			excess--
+		}
+	}
+
+	return result, nil
+}
+
+// finalizeInstances applies spec.deletionPolicy to the instances owned by the
+// MyResource and returns the provider name and the instances still waiting to
+// terminate. Teardown is complete once none remain.
+func (r *MyResourceReconciler) finalizeInstances(
+	ctx context.Context,
+	myRes *devopsv1.MyResource,
+) (string, []cloudclients.Instance, error) {
+	log := ctrl.Log.WithName("controller").WithValues("myresource", client.ObjectKeyFromObject(myRes))
+
+	if myRes.Spec.DeletionPolicy == devopsv1.DeletionPolicyRetain {
+		log.Info("Deletion policy is Retain; leaving cloud instances in place")
+		return "", nil, nil
+	}
+
+	provider, err := r.resolveProvider(ctx, myRes)
+	if errors.Is(err, cloudclients.ErrNoProvider) {
+		return "", nil, nil
+	}
+	if apierrors.IsNotFound(err) && myRes.Spec.DeletionPolicy == devopsv1.DeletionPolicyOrphan {
+		// Deleting a namespace often removes the Secret first. The instances are
+		// left in place either way, but they keep the owner UID tag, so no later
+		// MyResource adopts them.
+		log.Info("Credentials Secret is gone; orphaning cloud instances without releasing them")
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	instances, err := provider.ListInstances(ctx)
+	if err != nil {
+		return provider.Name(), nil, err
+	}
+
+	if myRes.Spec.DeletionPolicy == devopsv1.DeletionPolicyOrphan {
+		log.Info("Deletion policy is Orphan; releasing cloud instances", "count", len(instances))
+		for _, instance := range instances {
+			err := provider.UpdateTags(ctx, instance.ID, nil, []string{cloudclients.OwnerUIDTag})
+			if err != nil {
+				return provider.Name(), instances, err
+			}
+		}
+		return provider.Name(), nil, nil
+	}
+	for _, instance := range instances {
+		if !instance.Active() {
+			continue
+		}
+		if err := provider.DeleteInstance(ctx, instance.ID); err != nil {
+			return provider.Name(), instances, err
+		}
+	}
+
+	// Some clouds finish terminating asynchronously, so look again rather
+	// than assuming the deletes above took effect.
+	instances, err = provider.ListInstances(ctx)
+	if err != nil {
+		return provider.Name(), nil, err
+	}
+	if len(instances) > 0 {
+		return provider.Name(), instances, nil
+	}
+
+	if cleaner, ok := provider.(cloudclients.Cleaner); ok {
+		if err := cleaner.Cleanup(ctx); err != nil {
+			return provider.Name(), nil, err
+		}
+	}
+	return provider.Name(), nil, nil
+}
+
+// sortForDeletion orders instances so the best candidates for removal come
+// first: instances that are not running, then those the name template did not
+// name, by descending name, then the rest by descending ordinal.
+func sortForDeletion(instances []cloudclients.Instance, namer *instanceNamer) {
+	ordinals := make(map[string]int, len(instances))
+	for _, instance := range instances {
+		if index, ok := namer.ordinal(instance.Name); ok {
+			ordinals[instance.Name] = index
+		}
+	}
+	sort.SliceStable(instances, func(i, j int) bool {
+		iRunning := instances[i].State == cloudclients.InstanceRunning
+		jRunning := instances[j].State == cloudclients.InstanceRunning
+		if iRunning != jRunning {
+			return !iRunning
+		}
+		iIndex, iNamed := ordinals[instances[i].Name]
+		jIndex, jNamed := ordinals[instances[j].Name]
+		if iNamed != jNamed {
+			return !iNamed
+		}
+		if iNamed && iIndex != jIndex {
+			return iIndex > jIndex
+		}
+		return instances[i].Name > instances[j].Name
+	})
 }
 
 func (r *MyResourceReconciler) validateSpec(myRes *devopsv1.MyResource) error {
 
-    if myRes.Spec.DesiredCount < 0 {
-        return field.Invalid(
-            field.NewPath("spec").Child("desiredCount"),
-            myRes.Spec.DesiredCount,
-            "desiredCount cannot be negative",
-        )
-    }
-    return nil
+	// The CRD schema rejects negative counts on write, but objects stored before
+	// the schema gained its minimum can still carry one.
+	if myRes.Spec.DesiredCount < 0 {
+		return field.Invalid(
+			field.NewPath("spec").Child("desiredCount"),
+			myRes.Spec.DesiredCount,
+			"desiredCount cannot be negative",
+		)
+	}
+
+	if bootstrap := myRes.Spec.Bootstrap; bootstrap != nil {
+		var sources []string
+		if bootstrap.Script != "" {
+			sources = append(sources, "script")
+		}
+		if bootstrap.ConfigMapRef != nil {
+			sources = append(sources, "configMapRef")
+		}
+		if bootstrap.SecretRef != nil {
+			sources = append(sources, "secretRef")
+		}
+		if len(sources) > 1 {
+			return field.Invalid(
+				field.NewPath("spec").Child("bootstrap"),
+				strings.Join(sources, ", "),
+				"only one of script, configMapRef and secretRef may be set",
+			)
+		}
+	}
+	return nil
 }
@@ -2,16 +2,27 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"google.golang.org/api/googleapi"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+	"github.com/andyzhang8/k8s-custom-controller/pkg/cloudclients"
 )
 
 var _ = Describe("MyResource Controller", func() {
@@ -35,7 +46,7 @@ var _ = Describe("MyResource Controller", func() {
 						Name:      resourceName,
 						Namespace: "default",
 					},
-					// TODO(user): Specify other spec details if needed.
+					Spec: devopsv1.MyResourceSpec{GCPConfig: &devopsv1.GCPConfigSpec{}},
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 			}
@@ -52,8 +63,9 @@ var _ = Describe("MyResource Controller", func() {
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &MyResourceReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:    k8sClient,
+				Scheme:    k8sClient.Scheme(),
+				Providers: newFakeRegistry(&fakeProvider{}),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
@@ -63,4 +75,762 @@ var _ = Describe("MyResource Controller", func() {
 			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic
 		})
 	})
+
+	Context("When a cloud provider is configured", func() {
+		const resourceName = "test-provider-resource"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+		var fake *fakeProvider
+		var controllerReconciler *MyResourceReconciler
+
+		reconcileResource := func() {
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			fake = &fakeProvider{}
+			controllerReconciler = &MyResourceReconciler{
+				Client:    k8sClient,
+				Scheme:    k8sClient.Scheme(),
+				Providers: newFakeRegistry(fake),
+			}
+
+			By("creating a MyResource with a GCP config")
+			resource := &devopsv1.MyResource{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: devopsv1.MyResourceSpec{
+					DesiredCount: 2,
+					GCPConfig: &devopsv1.GCPConfigSpec{
+						ProjectID:   "test-project",
+						Zone:        "us-central1-a",
+						MachineType: "e2-medium",
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			resource := &devopsv1.MyResource{}
+			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			if errors.IsNotFound(err) {
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Cleanup the specific resource instance MyResource")
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			fake.asyncDelete = false
+			fake.listErr = nil
+			reconcileResource()
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, resource))
+			}).Should(BeTrue())
+		})
+
+		It("should create instances through the resolved provider", func() {
+			By("Reconciling until the finalizer is added and instances are created")
+			reconcileResource()
+			reconcileResource()
+
+			instances, err := fake.ListInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(2))
+			Expect(fake.lastRequest.Index).To(Equal(1))
+
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Provider).To(Equal(cloudclients.ProviderGCP))
+			Expect(resource.Status.CurrentCount).To(Equal(2))
+			Expect(resource.Status.ReadyCount).To(Equal(2))
+			Expect(resource.Status.ObservedGeneration).To(Equal(resource.Generation))
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, devopsv1.ConditionProgressing)).To(BeTrue())
+
+			By("Listing the instances in status")
+			Expect(resource.Status.Instances).To(HaveLen(2))
+			for _, instance := range resource.Status.Instances {
+				Expect(instance.Provider).To(Equal(cloudclients.ProviderGCP))
+				Expect(instance.Zone).To(Equal("us-central1-a"))
+				Expect(instance.State).To(Equal(cloudclients.InstanceRunning))
+				Expect(instance.CreationTime).NotTo(BeNil())
+			}
+
+			By("Reporting Ready once the instances are observed running")
+			reconcileResource()
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, devopsv1.ConditionReady)).To(BeTrue())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, devopsv1.ConditionCredentialsValid)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(resource.Status.Conditions, devopsv1.ConditionDegraded)).To(BeTrue())
+		})
+
+		It("should report Degraded when the spec is invalid", func() {
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Bootstrap = &devopsv1.BootstrapSpec{
+				Script: "#!/bin/sh",
+				ConfigMapRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "bootstrap"},
+					Key:                  "user-data",
+				},
+			}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			reconcileResource()
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.ObservedGeneration).To(Equal(resource.Generation))
+			degraded := meta.FindStatusCondition(resource.Status.Conditions, devopsv1.ConditionDegraded)
+			Expect(degraded).NotTo(BeNil())
+			Expect(degraded.Status).To(Equal(metav1.ConditionTrue))
+			Expect(degraded.Reason).To(Equal(reasonInvalidSpec))
+			Expect(meta.IsStatusConditionFalse(resource.Status.Conditions, devopsv1.ConditionReady)).To(BeTrue())
+		})
+
+		It("should have the API server reject specs that break the CRD rules", func() {
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+
+			invalid := []func(spec *devopsv1.MyResourceSpec){
+				func(spec *devopsv1.MyResourceSpec) { spec.DesiredCount = -1 },
+				func(spec *devopsv1.MyResourceSpec) { spec.AWSConfig = &devopsv1.AWSConfigSpec{Region: "us-east-1"} },
+				func(spec *devopsv1.MyResourceSpec) { spec.Provider = "aws" },
+				func(spec *devopsv1.MyResourceSpec) { spec.GCPConfig.Region = "europe-west1" },
+				func(spec *devopsv1.MyResourceSpec) { spec.GCPConfig.ProjectID = "other-project" },
+				func(spec *devopsv1.MyResourceSpec) { spec.GCPConfig.ProjectID = "" },
+			}
+			for _, mutate := range invalid {
+				updated := resource.DeepCopy()
+				mutate(&updated.Spec)
+				err := k8sClient.Update(ctx, updated)
+				Expect(errors.IsInvalid(err)).To(BeTrue(), "expected an invalid error, got %v", err)
+			}
+
+			By("Allowing a region that contains the zone")
+			resource.Spec.GCPConfig.Region = "us-central1"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+		})
+
+		It("should authenticate with the referenced credentials Secret", func() {
+			reconcileResource()
+
+			By("Referencing a Secret that does not exist yet")
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.GCPConfig.CredentialsSecretRef = "gcp-credentials"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			credentialsValid := meta.FindStatusCondition(resource.Status.Conditions, devopsv1.ConditionCredentialsValid)
+			Expect(credentialsValid).NotTo(BeNil())
+			Expect(credentialsValid.Status).To(Equal(metav1.ConditionFalse))
+			Expect(credentialsValid.Reason).To(Equal(reasonInvalidCredentials))
+
+			By("Creating the Secret")
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "gcp-credentials", Namespace: "default"},
+				Data:       map[string][]byte{cloudclients.GCPCredentialsKey: []byte(`{"type":"service_account"}`)},
+			}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
+			})
+
+			reconcileResource()
+			Expect(fake.creds).To(HaveKeyWithValue(cloudclients.GCPCredentialsKey, secret.Data[cloudclients.GCPCredentialsKey]))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, devopsv1.ConditionCredentialsValid)).To(BeTrue())
+		})
+
+		It("should only report credential failures through CredentialsValid", func() {
+			reconcileResource()
+			reconcileResource()
+
+			By("Failing to reach the cloud API for another reason")
+			fake.listErr = &googleapi.Error{Code: http.StatusServiceUnavailable}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(HaveOccurred())
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, devopsv1.ConditionCredentialsValid)).To(BeTrue())
+			degraded := meta.FindStatusCondition(resource.Status.Conditions, devopsv1.ConditionDegraded)
+			Expect(degraded.Status).To(Equal(metav1.ConditionTrue))
+			Expect(degraded.Reason).To(Equal(reasonProvisioningFailed))
+
+			By("Having the cloud API reject the credentials")
+			fake.listErr = &googleapi.Error{Code: http.StatusUnauthorized}
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			credentialsValid := meta.FindStatusCondition(resource.Status.Conditions, devopsv1.ConditionCredentialsValid)
+			Expect(credentialsValid.Status).To(Equal(metav1.ConditionFalse))
+			Expect(credentialsValid.Reason).To(Equal(reasonInvalidCredentials))
+
+			By("Recovering once the cloud accepts the credentials again")
+			fake.listErr = nil
+			reconcileResource()
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, devopsv1.ConditionCredentialsValid)).To(BeTrue())
+		})
+
+		It("should generate an admin password Secret when none is referenced", func() {
+			reconcileResource()
+
+			By("Switching to an Azure config without a password reference")
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.GCPConfig = nil
+			resource.Spec.AzureConfig = &devopsv1.AzureConfigSpec{Region: "eastus", AdminUsername: "azureuser"}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+
+			secret := &corev1.Secret{}
+			secretName := types.NamespacedName{Name: resourceName + "-admin-password", Namespace: "default"}
+			Expect(k8sClient.Get(ctx, secretName, secret)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
+			})
+			Expect(metav1.IsControlledBy(secret, resource)).To(BeTrue())
+			Expect(secret.Data).To(HaveKey("password"))
+			Expect(fake.lastRequest.AdminPassword).To(Equal(string(secret.Data["password"])))
+			Expect(fake.lastRequest.AdminPassword).To(HaveLen(generatedPasswordLength))
+		})
+
+		It("should pass the bootstrap script from a ConfigMap as user data", func() {
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "bootstrap", Namespace: "default"},
+				Data:       map[string]string{"init.sh": "#!/bin/sh\necho hello\n"},
+			}
+			Expect(k8sClient.Create(ctx, configMap)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, configMap)).To(Succeed())
+			})
+
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Bootstrap = &devopsv1.BootstrapSpec{
+				ConfigMapRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "bootstrap"},
+					Key:                  "init.sh",
+				},
+			}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+			reconcileResource()
+
+			Expect(fake.lastRequest.UserData).To(Equal("#!/bin/sh\necho hello\n"))
+		})
+
+		It("should authorize SSH keys from the spec and a Secret", func() {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "ssh-keys", Namespace: "default"},
+				StringData: map[string]string{"authorized_keys": "# team keys\nssh-ed25519 BBBB bob\n\n"},
+			}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
+			})
+
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.SSH = &devopsv1.SSHSpec{
+				PublicKeys: []string{"ssh-ed25519 AAAA alice"},
+				PublicKeysSecretRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "ssh-keys"},
+					Key:                  "authorized_keys",
+				},
+			}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+			reconcileResource()
+
+			Expect(fake.lastRequest.SSHUsername).To(Equal("myresource"))
+			Expect(fake.lastRequest.SSHPublicKeys).To(Equal([]string{"ssh-ed25519 AAAA alice", "ssh-ed25519 BBBB bob"}))
+		})
+
+		It("should render bootstrap templates per instance", func() {
+			reconcileResource()
+
+			By("Referencing an unknown template variable")
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Bootstrap = &devopsv1.BootstrapSpec{Script: "{{ .Hostname }}", Template: true}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			degraded := meta.FindStatusCondition(resource.Status.Conditions, devopsv1.ConditionDegraded)
+			Expect(degraded).NotTo(BeNil())
+			Expect(degraded.Reason).To(Equal(reasonInvalidBootstrap))
+			Expect(fake.nextID).To(Equal(0))
+
+			By("Fixing the template")
+			resource.Spec.Bootstrap.Script = "{{ .Name }}-{{ .Index }} {{ .Region }}/{{ .Zone }}"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+
+			Expect(fake.lastRequest.UserData).To(Equal(resourceName + "-1 us-central1/us-central1-a"))
+		})
+
+		It("should report a broken bootstrap template when no instance needs creating", func() {
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Bootstrap = &devopsv1.BootstrapSpec{Script: "{{ .Name }}-{{ .Index }}", Template: true}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+			reconcileResource()
+			reconcileResource()
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, devopsv1.ConditionReady)).To(BeTrue())
+
+			By("Breaking the template of the converged MyResource")
+			resource.Spec.Bootstrap.Script = "{{ .Missing }}"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			ready := meta.FindStatusCondition(resource.Status.Conditions, devopsv1.ConditionReady)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal(reasonInvalidBootstrap))
+			Expect(fake.instances).To(HaveLen(2))
+		})
+
+		It("should name instances deterministically and reuse freed names", func() {
+			reconcileResource()
+			reconcileResource()
+
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			sum := sha256.Sum256([]byte(resource.UID))
+			prefix := resourceName + "-" + hex.EncodeToString(sum[:])[:5]
+
+			instances, err := fake.ListInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(ConsistOf(
+				HaveField("Name", prefix+"-0"),
+				HaveField("Name", prefix+"-1"),
+			))
+
+			By("Deleting the first instance outside the controller")
+			Expect(fake.DeleteInstance(ctx, instances[0].ID)).To(Succeed())
+			reconcileResource()
+			Expect(fake.lastRequest.Name).To(Equal(instances[0].Name))
+			Expect(fake.lastRequest.Index).To(Equal(0))
+
+			By("Using a name template that ignores the index")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.DesiredCount = 3
+			resource.Spec.NameTemplate = "{{ .Namespace }}-vm"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			degraded := meta.FindStatusCondition(resource.Status.Conditions, devopsv1.ConditionDegraded)
+			Expect(degraded).NotTo(BeNil())
+			Expect(degraded.Reason).To(Equal(reasonInvalidSpec))
+
+			By("Fixing the template")
+			resource.Spec.NameTemplate = "{{ .Namespace }}-{{ .Name }}-{{ .Index }}"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+			Expect(fake.lastRequest.Name).To(Equal("default-" + resourceName + "-0"))
+		})
+
+		It("should scale down the highest ordinals first", func() {
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.DesiredCount = 11
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+			reconcileResource()
+			Expect(fake.instances).To(HaveLen(11))
+
+			By("Removing instances 9 and 10 rather than the names that sort last")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			sum := sha256.Sum256([]byte(resource.UID))
+			prefix := resourceName + "-" + hex.EncodeToString(sum[:])[:5]
+			resource.Spec.DesiredCount = 9
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+			instances, err := fake.ListInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(9))
+			Expect(instances).NotTo(ContainElement(HaveField("Name", prefix+"-9")))
+			Expect(instances).NotTo(ContainElement(HaveField("Name", prefix+"-10")))
+
+			By("Removing instances the template did not name before any it did")
+			namer, err := newInstanceNamer(fake, resource)
+			Expect(err).NotTo(HaveOccurred())
+			candidates := []cloudclients.Instance{
+				{Name: prefix + "-1", State: cloudclients.InstanceRunning},
+				{Name: prefix + "-10", State: cloudclients.InstanceRunning},
+				{Name: "hand-made", State: cloudclients.InstanceRunning},
+				{Name: prefix + "-9", State: cloudclients.InstanceRunning},
+			}
+			sortForDeletion(candidates, namer)
+			Expect(candidates).To(HaveExactElements(
+				HaveField("Name", "hand-made"),
+				HaveField("Name", prefix+"-10"),
+				HaveField("Name", prefix+"-9"),
+				HaveField("Name", prefix+"-1"),
+			))
+		})
+
+		It("should report a broken name template when no instance needs creating", func() {
+			reconcileResource()
+			reconcileResource()
+
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.NameTemplate = "{{ .Namespace }}-vm"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			ready := meta.FindStatusCondition(resource.Status.Conditions, devopsv1.ConditionReady)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal(reasonInvalidSpec))
+			Expect(fake.instances).To(HaveLen(2))
+		})
+
+		It("should scale through the scale subresource", func() {
+			reconcileResource()
+			reconcileResource()
+
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			scale := &autoscalingv1.Scale{}
+			Expect(k8sClient.SubResource("scale").Get(ctx, resource, scale)).To(Succeed())
+			Expect(scale.Spec.Replicas).To(BeEquivalentTo(2))
+			Expect(scale.Status.Replicas).To(BeEquivalentTo(2))
+			Expect(scale.Status.Selector).To(Equal(devopsv1.MyResourceLabel + "=" + resourceName))
+
+			By("Scaling up while a reconcile still holds the old object")
+			stale := resource.DeepCopy()
+			scale.Spec.Replicas = 3
+			Expect(k8sClient.SubResource("scale").Update(ctx, resource, client.WithSubResourceBody(scale))).To(Succeed())
+			stale.Status.Phase = "Running"
+			Expect(controllerReconciler.updateStatus(ctx, stale)).To(Succeed())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Spec.DesiredCount).To(Equal(3))
+			Expect(resource.Status.ObservedGeneration).To(BeNumerically("<", resource.Generation))
+
+			By("Keeping the cached provider across the scale")
+			_, cached := controllerReconciler.cache.get(resource)
+			Expect(cached).To(BeTrue())
+
+			reconcileResource()
+			Expect(fake.instances).To(HaveLen(3))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.CurrentCount).To(Equal(3))
+			Expect(resource.Status.ObservedGeneration).To(Equal(resource.Generation))
+		})
+
+		It("should adopt an instance whose creation was interrupted", func() {
+			reconcileResource()
+			reconcileResource()
+
+			By("Launching an instance the controller has not recorded or listed yet")
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			launched, err := fake.CreateInstance(ctx, cloudclients.InstanceRequest{Name: "launched", ClientToken: "token-1"})
+			Expect(err).NotTo(HaveOccurred())
+			fake.unlisted = map[string]bool{launched.ID: true}
+			resource.Status.PendingCreates = []devopsv1.PendingCreate{
+				{Name: "launched", Index: 2, ClientToken: "token-1"},
+			}
+			Expect(k8sClient.Status().Update(ctx, resource)).To(Succeed())
+
+			By("Scaling up")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.DesiredCount = 3
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+
+			Expect(fake.lastRequest.Name).To(Equal("launched"))
+			Expect(fake.lastRequest.ClientToken).To(Equal("token-1"))
+			instances, err := fake.ListInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(3))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.PendingCreates).To(BeEmpty())
+		})
+
+		It("should converge to the instances that actually exist", func() {
+			reconcileResource()
+			reconcileResource()
+
+			By("Deleting an instance outside the controller")
+			instances, err := fake.ListInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fake.DeleteInstance(ctx, instances[0].ID)).To(Succeed())
+
+			By("Reconciling again")
+			reconcileResource()
+
+			instances, err = fake.ListInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(2))
+
+			By("Scaling down")
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.DesiredCount = 1
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+
+			instances, err = fake.ListInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.CurrentCount).To(Equal(1))
+		})
+
+		It("should tag instances and reconcile tag drift", func() {
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Labels = map[string]string{"team": "payments", "unrelated": "x"}
+			resource.Spec.PropagateLabels = []string{"team"}
+			resource.Spec.Tags = map[string]string{"cost-center": "cc-42"}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			reconcileResource()
+			reconcileResource()
+
+			instances, err := fake.ListInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(2))
+			for _, instance := range instances {
+				Expect(instance.Tags).To(HaveKeyWithValue("team", "payments"))
+				Expect(instance.Tags).To(HaveKeyWithValue("cost-center", "cc-42"))
+				Expect(instance.Tags).NotTo(HaveKey("unrelated"))
+			}
+
+			By("Changing a tag outside the controller and dropping one from the spec")
+			Expect(fake.UpdateTags(ctx, instances[0].ID, map[string]string{"cost-center": "wrong"}, nil)).To(Succeed())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.ManagedTags).To(ConsistOf("cost-center", "team"))
+			resource.Spec.PropagateLabels = nil
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+
+			instances, err = fake.ListInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			for _, instance := range instances {
+				Expect(instance.Tags).To(HaveKeyWithValue("cost-center", "cc-42"))
+				Expect(instance.Tags).NotTo(HaveKey("team"))
+				Expect(instance.Tags).To(HaveKey(cloudclients.OwnerUIDTag))
+			}
+		})
+
+		It("should boot scale-ups from the image resolved for the first instances", func() {
+			reconcileResource()
+			reconcileResource()
+
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Image).NotTo(BeNil())
+			Expect(resource.Status.Image.ID).To(Equal("projects/test-images/global/images/test-v1"))
+
+			By("Scaling up")
+			resource.Spec.DesiredCount = 3
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+
+			instances, err := fake.ListInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(3))
+			for _, instance := range instances {
+				Expect(instance.Image).To(Equal("projects/test-images/global/images/test-v1"))
+			}
+			Expect(fake.imageResolves).To(Equal(1))
+		})
+
+		It("should terminate instances before removing the finalizer", func() {
+			reconcileResource()
+			reconcileResource()
+			fake.asyncDelete = true
+
+			By("Deleting the MyResource")
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			reconcileResource()
+
+			By("Keeping the finalizer while instances are terminating")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Finalizers).To(ContainElement(myResourceFinalizer))
+			Expect(resource.Status.Phase).To(Equal("Deleting"))
+			Expect(resource.Status.CurrentCount).To(Equal(2))
+			Expect(fake.cleanups).To(BeZero())
+
+			By("Removing the finalizer once termination completes")
+			fake.finishTerminations()
+			reconcileResource()
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, resource))
+			}).Should(BeTrue())
+			Expect(fake.cleanups).To(Equal(1))
+		})
+
+		It("should orphan instances for a later MyResource to adopt", func() {
+			reconcileResource()
+			reconcileResource()
+
+			By("Deleting the MyResource with the Orphan policy")
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.DeletionPolicy = devopsv1.DeletionPolicyOrphan
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			reconcileResource()
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, resource))
+			}).Should(BeTrue())
+
+			Expect(fake.instances).To(HaveLen(2))
+			for _, instance := range fake.instances {
+				Expect(instance.Tags).NotTo(HaveKey(cloudclients.OwnerUIDTag))
+			}
+
+			By("Leaving the instances alone for a MyResource with another name")
+			other := &devopsv1.MyResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-resource", Namespace: "default"},
+				Spec: devopsv1.MyResourceSpec{
+					GCPConfig: &devopsv1.GCPConfigSpec{ProjectID: "test-project", Zone: "us-central1-a"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, other)).To(Succeed())
+			otherRequest := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(other)}
+			_, err := controllerReconciler.Reconcile(ctx, otherRequest)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = controllerReconciler.Reconcile(ctx, otherRequest)
+			Expect(err).NotTo(HaveOccurred())
+			for _, instance := range fake.instances {
+				Expect(instance.Tags).NotTo(HaveKey(cloudclients.OwnerUIDTag))
+			}
+			Expect(k8sClient.Delete(ctx, other)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, otherRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, otherRequest.NamespacedName, other))
+			}).Should(BeTrue())
+
+			By("Recreating a MyResource with the same name")
+			resource = &devopsv1.MyResource{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: devopsv1.MyResourceSpec{
+					DesiredCount: 2,
+					GCPConfig:    &devopsv1.GCPConfigSpec{ProjectID: "test-project", Zone: "us-central1-a"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			reconcileResource()
+			reconcileResource()
+
+			By("Adopting the orphaned instances instead of creating new ones")
+			instances, err := fake.ListInstances(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveLen(2))
+			Expect(fake.nextID).To(Equal(2))
+		})
+
+		It("should finalize after the credentials Secret is deleted first", func() {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "gcp-teardown-credentials", Namespace: "default"},
+				Data:       map[string][]byte{cloudclients.GCPCredentialsKey: []byte(`{"type":"service_account"}`)},
+			}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+			resource := &devopsv1.MyResource{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.GCPConfig.CredentialsSecretRef = secret.Name
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+			reconcileResource()
+			Expect(fake.instances).To(HaveLen(2))
+
+			By("Deleting the Secret before the MyResource, as namespace deletion may")
+			Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
+			controllerReconciler.cache.invalidate(typeNamespacedName)
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+
+			By("Keeping the finalizer and saying why when the policy is Delete")
+			reconcileResource()
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Finalizers).To(ContainElement(myResourceFinalizer))
+			deleting := meta.FindStatusCondition(resource.Status.Conditions, devopsv1.ConditionDeleting)
+			Expect(deleting).NotTo(BeNil())
+			Expect(deleting.Status).To(Equal(metav1.ConditionTrue))
+			Expect(deleting.Reason).To(Equal(reasonCredentialsMissing))
+			Expect(deleting.Message).To(ContainSubstring(myResourceFinalizer))
+			Expect(fake.instances).To(HaveLen(2))
+
+			By("Removing the finalizer once the policy leaves the instances behind")
+			resource.Spec.DeletionPolicy = devopsv1.DeletionPolicyOrphan
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			reconcileResource()
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, resource))
+			}).Should(BeTrue())
+			Expect(fake.instances).To(HaveLen(2))
+		})
+	})
+
+	Context("When a referenced Secret changes", func() {
+		It("should enqueue the referencing MyResources and drop their cached providers", func() {
+			ctx := context.Background()
+			referencing := &devopsv1.MyResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "uses-credentials", Namespace: "default", UID: "uid-1"},
+				Spec: devopsv1.MyResourceSpec{
+					GCPConfig: &devopsv1.GCPConfigSpec{CredentialsSecretRef: "gcp-credentials"},
+				},
+			}
+			unrelated := &devopsv1.MyResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "no-credentials", Namespace: "default", UID: "uid-2"},
+				Spec:       devopsv1.MyResourceSpec{GCPConfig: &devopsv1.GCPConfigSpec{}},
+			}
+			c := clientfake.NewClientBuilder().
+				WithScheme(scheme.Scheme).
+				WithObjects(referencing, unrelated).
+				WithIndex(&devopsv1.MyResource{}, secretRefIndex, func(obj client.Object) []string {
+					return referencedSecrets(obj.(*devopsv1.MyResource))
+				}).
+				Build()
+			controllerReconciler := &MyResourceReconciler{Client: c, Scheme: scheme.Scheme}
+			controllerReconciler.cache.put(referencing, &fakeProvider{})
+			controllerReconciler.cache.put(unrelated, &fakeProvider{})
+
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "gcp-credentials", Namespace: "default"}}
+			requests := controllerReconciler.myResourcesForSecret(ctx, secret)
+			Expect(requests).To(ConsistOf(reconcile.Request{NamespacedName: client.ObjectKeyFromObject(referencing)}))
+
+			_, cached := controllerReconciler.cache.get(referencing)
+			Expect(cached).To(BeFalse())
+			_, cached = controllerReconciler.cache.get(unrelated)
+			Expect(cached).To(BeTrue())
+		})
+	})
 })
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"text/template"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+	"github.com/andyzhang8/k8s-custom-controller/pkg/cloudclients"
+)
+
+const (
+	// defaultNameTemplate is used when spec.nameTemplate is empty.
+	defaultNameTemplate = "{{ .Prefix }}-{{ .Hash }}-{{ .Index }}"
+	// namePrefixMaxLength keeps default names within GCE's 63 characters.
+	namePrefixMaxLength = 40
+	// nameHashLength is the number of hex digits of the UID hash in names.
+	nameHashLength = 5
+)
+
+// digitRuns finds the candidate ordinals in an instance name.
+var digitRuns = regexp.MustCompile(`[0-9]+`)
+
+// errInvalidNameTemplate marks name templates that fail to parse or render,
+// or that do not give each instance its own name.
+var errInvalidNameTemplate = errors.New("invalid name template")
+
+// nameVars are the variables available to name templates. Keep the list in
+// the MyResourceSpec.NameTemplate documentation in sync.
+type nameVars struct {
+	Prefix    string
+	Name      string
+	Namespace string
+	Hash      string
+	Index     int
+}
+
+// instanceNamer generates the name of each instance from its ordinal.
+type instanceNamer struct {
+	tmpl      *template.Template
+	vars      nameVars
+	normalize func(string) string
+}
+
+// newInstanceNamer parses the spec's name template and checks it gives the
+// first instances distinct names once normalized for the provider.
+func newInstanceNamer(provider cloudclients.Provider, myRes *devopsv1.MyResource) (*instanceNamer, error) {
+	text := myRes.Spec.NameTemplate
+	if text == "" {
+		text = defaultNameTemplate
+	}
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidNameTemplate, err)
+	}
+
+	prefix := myRes.Spec.NamePrefix
+	if prefix == "" {
+		prefix = myRes.Name
+	}
+	if len(prefix) > namePrefixMaxLength {
+		prefix = prefix[:namePrefixMaxLength]
+	}
+	sum := sha256.Sum256([]byte(myRes.UID))
+	n := &instanceNamer{
+		tmpl: tmpl,
+		vars: nameVars{
+			Prefix:    prefix,
+			Name:      myRes.Name,
+			Namespace: myRes.Namespace,
+			Hash:      hex.EncodeToString(sum[:])[:nameHashLength],
+		},
+		normalize: func(name string) string { return name },
+	}
+	if normalizer, ok := provider.(cloudclients.NameNormalizer); ok {
+		n.normalize = normalizer.NormalizeName
+	}
+
+	first, err := n.name(0)
+	if err != nil {
+		return nil, err
+	}
+	second, err := n.name(1)
+	if err != nil {
+		return nil, err
+	}
+	if first == second {
+		return nil, fmt.Errorf("%w: every instance is named %q; include {{ .Index }}", errInvalidNameTemplate, first)
+	}
+	return n, nil
+}
+
+// name returns the name of the instance with the given ordinal.
+func (n *instanceNamer) name(index int) (string, error) {
+	vars := n.vars
+	vars.Index = index
+
+	var buf bytes.Buffer
+	if err := n.tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("%w: %w", errInvalidNameTemplate, err)
+	}
+	return n.normalize(buf.String()), nil
+}
+
+// ordinal returns the ordinal the template would have given the instance
+// called name, or false if the template does not produce that name.
+func (n *instanceNamer) ordinal(name string) (int, bool) {
+	for _, digits := range digitRuns.FindAllString(name, -1) {
+		index, err := strconv.Atoi(digits)
+		if err != nil {
+			continue
+		}
+		if candidate, err := n.name(index); err == nil && candidate == name {
+			return index, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,337 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+	"github.com/andyzhang8/k8s-custom-controller/pkg/cloudclients"
+)
+
+const (
+	// generatedPasswordKey is the key the generated admin password is stored under.
+	generatedPasswordKey = "password"
+	// generatedPasswordLength satisfies Azure's 12-123 character limit with room to spare.
+	generatedPasswordLength = 24
+)
+
+// Character classes for generated passwords. Azure requires three of the four.
+var passwordClasses = []string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"0123456789",
+	"!@#$%^&*()-_=+[]{}",
+}
+
+// instanceRequests builds the CreateInstance request for each new instance.
+type instanceRequests struct {
+	base  cloudclients.InstanceRequest
+	namer *instanceNamer
+	// bootstrap is set when spec.bootstrap is a template.
+	bootstrap *bootstrapTemplate
+}
+
+// next returns the request for the instance with the lowest ordinal whose
+// name is not in used, under a new client token, and adds that name to used.
+func (reqs instanceRequests) next(used map[string]bool) (cloudclients.InstanceRequest, error) {
+	// At most len(used) ordinals can be taken, so one of these is free.
+	for index := 0; index <= len(used); index++ {
+		name, err := reqs.namer.name(index)
+		if err != nil {
+			return reqs.base, err
+		}
+		if !used[name] {
+			used[name] = true
+			return reqs.forCreate(devopsv1.PendingCreate{
+				Name:        name,
+				Index:       index,
+				ClientToken: string(uuid.NewUUID()),
+			})
+		}
+	}
+	return reqs.base, fmt.Errorf("%w: no free instance name", errInvalidNameTemplate)
+}
+
+// forCreate returns the request for the given, possibly retried, creation.
+func (reqs instanceRequests) forCreate(create devopsv1.PendingCreate) (cloudclients.InstanceRequest, error) {
+	req := reqs.base
+	req.Index, req.Name, req.ClientToken = create.Index, create.Name, create.ClientToken
+	if reqs.bootstrap != nil {
+		userData, err := reqs.bootstrap.render(req.Index)
+		if err != nil {
+			return req, err
+		}
+		req.UserData = userData
+	}
+	return req, nil
+}
+
+// newInstanceRequests prepares the requests for new instances from the
+// templates in the spec. convergeInstances calls it on every reconcile so a
+// broken template is reported even when no instance needs creating;
+// resolveInstanceRequests looks up the rest once one does.
+func (r *MyResourceReconciler) newInstanceRequests(
+	ctx context.Context,
+	provider cloudclients.Provider,
+	myRes *devopsv1.MyResource,
+) (instanceRequests, error) {
+	var reqs instanceRequests
+	reqs.base.Tags = desiredTags(provider, myRes)
+
+	namer, err := newInstanceNamer(provider, myRes)
+	if err != nil {
+		return reqs, err
+	}
+	reqs.namer = namer
+
+	userData, err := r.bootstrapPayload(ctx, myRes)
+	if err != nil {
+		return reqs, err
+	}
+	reqs.base.UserData = userData
+	if bootstrap := myRes.Spec.Bootstrap; bootstrap != nil && bootstrap.Template {
+		vars := bootstrapVars{
+			Name:      myRes.Name,
+			Namespace: myRes.Namespace,
+			Provider:  provider.Name(),
+		}
+		vars.Region, vars.Zone = specLocation(&myRes.Spec)
+		if bootstrap.JoinTokenSecretRef != nil {
+			if vars.JoinToken, err = r.secretKey(ctx, myRes.Namespace, bootstrap.JoinTokenSecretRef); err != nil {
+				return reqs, err
+			}
+		}
+		if reqs.bootstrap, err = parseBootstrapTemplate(userData, vars); err != nil {
+			return reqs, err
+		}
+	}
+	return reqs, nil
+}
+
+// resolveInstanceRequests fills in the inputs CreateInstance needs from the
+// cluster and the cloud. It may record the resolved image in myRes.Status.
+func (r *MyResourceReconciler) resolveInstanceRequests(
+	ctx context.Context,
+	provider cloudclients.Provider,
+	myRes *devopsv1.MyResource,
+	reqs *instanceRequests,
+) error {
+	password, err := r.adminPassword(ctx, myRes)
+	if err != nil {
+		return err
+	}
+	reqs.base.AdminPassword = password
+
+	if myRes.Spec.SSH != nil {
+		reqs.base.SSHUsername = myRes.Spec.SSH.Username
+		if reqs.base.SSHPublicKeys, err = r.sshPublicKeys(ctx, myRes); err != nil {
+			return err
+		}
+	}
+
+	if resolver, ok := provider.(cloudclients.ImageResolver); ok {
+		image, err := pinnedImage(ctx, resolver, myRes)
+		if err != nil {
+			return err
+		}
+		reqs.base.Image = image
+	}
+	return nil
+}
+
+// pinnedImage returns the image recorded in status for the spec's current
+// image reference, resolving and recording it first if the reference changed.
+func pinnedImage(ctx context.Context, resolver cloudclients.ImageResolver, myRes *devopsv1.MyResource) (string, error) {
+	source := resolver.ImageSource()
+	if pinned := myRes.Status.Image; pinned != nil && pinned.Source == source {
+		return pinned.ID, nil
+	}
+
+	image, err := resolver.ResolveImage(ctx)
+	if err != nil {
+		return "", err
+	}
+	myRes.Status.Image = &devopsv1.ResolvedImage{Source: source, ID: image}
+	return image, nil
+}
+
+// adminPassword returns the admin password selected by the spec. Azure VMs
+// need one unless they use SSH keys, so if the spec selects none it is
+// generated into a Secret owned by the MyResource and reused on later calls.
+func (r *MyResourceReconciler) adminPassword(ctx context.Context, myRes *devopsv1.MyResource) (string, error) {
+	switch {
+	case myRes.Spec.AzureConfig != nil && myRes.Spec.AzureConfig.AdminPasswordSecretRef != nil:
+		return r.secretKey(ctx, myRes.Namespace, myRes.Spec.AzureConfig.AdminPasswordSecretRef)
+	case myRes.Spec.AzureConfig != nil && myRes.Spec.SSH == nil:
+		return r.generatedPassword(ctx, myRes)
+	case myRes.Spec.AWSConfig != nil && myRes.Spec.AWSConfig.AdminPasswordSecretRef != nil:
+		return r.secretKey(ctx, myRes.Namespace, myRes.Spec.AWSConfig.AdminPasswordSecretRef)
+	default:
+		return "", nil
+	}
+}
+
+// sshPublicKeys returns the inline keys of spec.ssh followed by those in the
+// referenced Secret, skipping blank lines and comments.
+func (r *MyResourceReconciler) sshPublicKeys(ctx context.Context, myRes *devopsv1.MyResource) ([]string, error) {
+	ssh := myRes.Spec.SSH
+	keys := append([]string(nil), ssh.PublicKeys...)
+	if ssh.PublicKeysSecretRef != nil {
+		data, err := r.secretKey(ctx, myRes.Namespace, ssh.PublicKeysSecretRef)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(data, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				keys = append(keys, line)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// bootstrapPayload returns the user data selected by spec.bootstrap.
+func (r *MyResourceReconciler) bootstrapPayload(ctx context.Context, myRes *devopsv1.MyResource) (string, error) {
+	bootstrap := myRes.Spec.Bootstrap
+	switch {
+	case bootstrap == nil:
+		return "", nil
+	case bootstrap.ConfigMapRef != nil:
+		return r.configMapKey(ctx, myRes.Namespace, bootstrap.ConfigMapRef)
+	case bootstrap.SecretRef != nil:
+		return r.secretKey(ctx, myRes.Namespace, bootstrap.SecretRef)
+	default:
+		return bootstrap.Script, nil
+	}
+}
+
+// configMapKey reads the value selected by ref from a ConfigMap in namespace.
+func (r *MyResourceReconciler) configMapKey(ctx context.Context, namespace string, ref *corev1.ConfigMapKeySelector) (string, error) {
+	var configMap corev1.ConfigMap
+	if err := r.reader().Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.Name}, &configMap); err != nil {
+		if apierrors.IsNotFound(err) && ref.Optional != nil && *ref.Optional {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get configmap %q: %w", ref.Name, err)
+	}
+	if value, ok := configMap.Data[ref.Key]; ok {
+		return value, nil
+	}
+	if value, ok := configMap.BinaryData[ref.Key]; ok {
+		return string(value), nil
+	}
+	if ref.Optional != nil && *ref.Optional {
+		return "", nil
+	}
+	return "", fmt.Errorf("configmap %q has no key %q", ref.Name, ref.Key)
+}
+
+// secretKey reads the value selected by ref from a Secret in namespace.
+func (r *MyResourceReconciler) secretKey(ctx context.Context, namespace string, ref *corev1.SecretKeySelector) (string, error) {
+	var secret corev1.Secret
+	if err := r.reader().Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.Name}, &secret); err != nil {
+		if apierrors.IsNotFound(err) && ref.Optional != nil && *ref.Optional {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get secret %q: %w", ref.Name, err)
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		if ref.Optional != nil && *ref.Optional {
+			return "", nil
+		}
+		return "", fmt.Errorf("secret %q has no key %q", ref.Name, ref.Key)
+	}
+	return string(value), nil
+}
+
+// generatedPasswordSecretName returns the name of the Secret holding the generated admin password.
+func generatedPasswordSecretName(myRes *devopsv1.MyResource) string {
+	return myRes.Name + "-admin-password"
+}
+
+// generatedPassword returns the password stored in the MyResource's generated
+// password Secret, creating the Secret first if it does not exist.
+func (r *MyResourceReconciler) generatedPassword(ctx context.Context, myRes *devopsv1.MyResource) (string, error) {
+	ref := &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: generatedPasswordSecretName(myRes)},
+		Key:                  generatedPasswordKey,
+	}
+	password, err := r.secretKey(ctx, myRes.Namespace, ref)
+	if !apierrors.IsNotFound(err) {
+		return password, err
+	}
+
+	password, err = randomPassword()
+	if err != nil {
+		return "", err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ref.Name,
+			Namespace: myRes.Namespace,
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: map[string]string{generatedPasswordKey: password},
+	}
+	if err := controllerutil.SetControllerReference(myRes, secret, r.Scheme); err != nil {
+		return "", err
+	}
+	if err := r.Create(ctx, secret); err != nil {
+		return "", fmt.Errorf("failed to create admin password secret %q: %w", ref.Name, err)
+	}
+	return password, nil
+}
+
+// randomPassword returns a password containing every class in passwordClasses.
+func randomPassword() (string, error) {
+	var all string
+	for _, class := range passwordClasses {
+		all += class
+	}
+
+	password := make([]byte, 0, generatedPasswordLength)
+	for _, class := range passwordClasses {
+		c, err := randomChar(class)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	for len(password) < generatedPasswordLength {
+		c, err := randomChar(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	// Shuffle so the guaranteed characters are not always at the front.
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+	return string(password), nil
+}
+
+func randomChar(chars string) (byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate password: %w", err)
+	}
+	return chars[n.Int64()], nil
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+	"github.com/andyzhang8/k8s-custom-controller/pkg/cloudclients"
+)
+
+// Reasons used on MyResource conditions.
+const (
+	reasonInvalidSpec        = "InvalidSpec"
+	reasonNoProvider         = "NoProviderConfigured"
+	reasonProviderInitFailed = "ProviderInitFailed"
+	reasonInvalidCredentials = "InvalidCredentials"
+	reasonCredentialsMissing = "CredentialsMissing"
+	reasonCloudAPIReachable  = "CloudAPIReachable"
+	reasonProvisioningFailed = "ProvisioningFailed"
+	reasonInvalidBootstrap   = "InvalidBootstrap"
+	reasonScaling            = "Scaling"
+	reasonInstancesSettling  = "InstancesSettling"
+	reasonConverged          = "Converged"
+	reasonReconcileSucceeded = "ReconcileSucceeded"
+	reasonFinalizing         = "Finalizing"
+	reasonFinalizeFailed     = "FinalizeFailed"
+)
+
+// setCondition records a condition against the current generation of myRes.
+func setCondition(myRes *devopsv1.MyResource, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&myRes.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: myRes.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// markFailed sets the conditions for a reconcile that could not make progress.
+func markFailed(myRes *devopsv1.MyResource, reason, message string) {
+	myRes.Status.Phase = "Error"
+	setCondition(myRes, devopsv1.ConditionReady, metav1.ConditionFalse, reason, message)
+	setCondition(myRes, devopsv1.ConditionProgressing, metav1.ConditionFalse, reason, message)
+	setCondition(myRes, devopsv1.ConditionDegraded, metav1.ConditionTrue, reason, message)
+}
+
+// setConvergedConditions sets the conditions after a successful convergence pass.
+func setConvergedConditions(myRes *devopsv1.MyResource, result convergeResult) {
+	desired := myRes.Spec.DesiredCount
+	current := len(result.instances)
+	message := fmt.Sprintf("%d/%d instance(s) running", current, desired)
+
+	setCondition(myRes, devopsv1.ConditionDegraded, metav1.ConditionFalse, reasonReconcileSucceeded, message)
+	switch {
+	case result.created > 0 || result.deleted > 0:
+		setCondition(myRes, devopsv1.ConditionReady, metav1.ConditionFalse, reasonScaling, message)
+		setCondition(myRes, devopsv1.ConditionProgressing, metav1.ConditionTrue, reasonScaling,
+			fmt.Sprintf("created %d and deleted %d instance(s)", result.created, result.deleted))
+	case result.settling:
+		setCondition(myRes, devopsv1.ConditionReady, metav1.ConditionFalse, reasonInstancesSettling, message)
+		setCondition(myRes, devopsv1.ConditionProgressing, metav1.ConditionTrue, reasonInstancesSettling,
+			"waiting for instances to finish starting or stopping")
+	default:
+		setCondition(myRes, devopsv1.ConditionReady, metav1.ConditionTrue, reasonConverged, message)
+		setCondition(myRes, devopsv1.ConditionProgressing, metav1.ConditionFalse, reasonConverged, message)
+	}
+}
+
+// readyCount returns how many of instances are running.
+func readyCount(instances []cloudclients.Instance) int {
+	count := 0
+	for _, instance := range instances {
+		if instance.State == cloudclients.InstanceRunning {
+			count++
+		}
+	}
+	return count
+}
+
+// instanceStatuses converts the observed instances for status.instances, ordered by name.
+func instanceStatuses(providerName string, instances []cloudclients.Instance) []devopsv1.InstanceStatus {
+	if len(instances) == 0 {
+		return nil
+	}
+	statuses := make([]devopsv1.InstanceStatus, 0, len(instances))
+	for _, instance := range instances {
+		status := devopsv1.InstanceStatus{
+			Provider:  providerName,
+			ID:        instance.ID,
+			Name:      instance.Name,
+			Zone:      instance.Zone,
+			PrivateIP: instance.PrivateIP,
+			PublicIP:  instance.PublicIP,
+			State:     instance.State,
+			Image:     instance.Image,
+		}
+		if !instance.CreatedAt.IsZero() {
+			created := metav1.NewTime(instance.CreatedAt)
+			status.CreationTime = &created
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Name != statuses[j].Name {
+			return statuses[i].Name < statuses[j].Name
+		}
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+	"github.com/andyzhang8/k8s-custom-controller/pkg/cloudclients"
+)
+
+// ownershipTagKeys are written by the providers themselves and can never be
+// set or removed through spec.tags.
+var ownershipTagKeys = map[string]bool{
+	cloudclients.OwnerNamespaceTag: true,
+	cloudclients.OwnerNameTag:      true,
+	cloudclients.OwnerUIDTag:       true,
+}
+
+// desiredTags returns the tags the spec asks for on every instance, in the
+// form the provider writes them: the propagated labels overlaid with
+// spec.tags, without the ownership tags.
+func desiredTags(provider cloudclients.Provider, myRes *devopsv1.MyResource) map[string]string {
+	tags := make(map[string]string, len(myRes.Spec.PropagateLabels)+len(myRes.Spec.Tags))
+	for _, key := range myRes.Spec.PropagateLabels {
+		if value, ok := myRes.Labels[key]; ok {
+			tags[key] = value
+		}
+	}
+	for key, value := range myRes.Spec.Tags {
+		tags[key] = value
+	}
+
+	if normalizer, ok := provider.(cloudclients.TagNormalizer); ok {
+		tags = normalizer.NormalizeTags(tags)
+	}
+	for key := range tags {
+		if ownershipTagKeys[key] {
+			delete(tags, key)
+		}
+	}
+	return tags
+}
+
+// reconcileTags sets the desired tags on each instance where they differ and
+// removes the tags in managed, the keys applied by an earlier reconcile, that
+// are no longer desired. It returns the keys to record as managed.
+func reconcileTags(
+	ctx context.Context,
+	provider cloudclients.Provider,
+	instances []cloudclients.Instance,
+	desired map[string]string,
+	managed []string,
+) ([]string, error) {
+	for _, instance := range instances {
+		set := make(map[string]string)
+		for key, value := range desired {
+			if current, ok := instance.Tags[key]; !ok || current != value {
+				set[key] = value
+			}
+		}
+		var remove []string
+		for _, key := range managed {
+			if _, keep := desired[key]; keep || ownershipTagKeys[key] {
+				continue
+			}
+			if _, ok := instance.Tags[key]; ok {
+				remove = append(remove, key)
+			}
+		}
+		if len(set) == 0 && len(remove) == 0 {
+			continue
+		}
+		if err := provider.UpdateTags(ctx, instance.ID, set, remove); err != nil {
+			return managed, err
+		}
+	}
+
+	keys := make([]string, 0, len(desired))
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+	"github.com/andyzhang8/k8s-custom-controller/pkg/cloudclients"
+)
+
+// providerCache keeps the cloud provider built for each MyResource so the
+// cloud clients are not rebuilt on every reconcile. An entry is only reused
+// for the same object and spec, ignoring desiredCount so scaling keeps the
+// clients; credential changes are handled by invalidating the entry when a
+// referenced Secret changes.
+type providerCache struct {
+	mu      sync.Mutex
+	entries map[types.NamespacedName]providerCacheEntry
+}
+
+type providerCacheEntry struct {
+	uid      types.UID
+	spec     devopsv1.MyResourceSpec
+	provider cloudclients.Provider
+}
+
+// providerSpec returns the part of the spec a provider is built from.
+func providerSpec(myRes *devopsv1.MyResource) devopsv1.MyResourceSpec {
+	spec := *myRes.Spec.DeepCopy()
+	spec.DesiredCount = 0
+	return spec
+}
+
+// get returns the cached provider for myRes, if it is still current.
+func (c *providerCache) get(myRes *devopsv1.MyResource) (cloudclients.Provider, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[client.ObjectKeyFromObject(myRes)]
+	if !ok || entry.uid != myRes.UID || !equality.Semantic.DeepEqual(entry.spec, providerSpec(myRes)) {
+		return nil, false
+	}
+	return entry.provider, true
+}
+
+func (c *providerCache) put(myRes *devopsv1.MyResource, provider cloudclients.Provider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[types.NamespacedName]providerCacheEntry)
+	}
+	c.entries[client.ObjectKeyFromObject(myRes)] = providerCacheEntry{
+		uid:      myRes.UID,
+		spec:     providerSpec(myRes),
+		provider: provider,
+	}
+}
+
+// invalidate drops the cached provider for the MyResource with the given key.
+func (c *providerCache) invalidate(key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
@@ -0,0 +1,191 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+	"github.com/andyzhang8/k8s-custom-controller/pkg/cloudclients"
+)
+
+// +kubebuilder:webhook:path=/mutate-devops-example-com-v1-myresource,mutating=true,failurePolicy=fail,sideEffects=None,groups=devops.example.com,resources=myresources,verbs=create,versions=v1,name=mmyresource-v1.kb.io,admissionReviewVersions=v1
+
+// +kubebuilder:rbac:groups=devops.example.com,resources=myresourcedefaults,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// MyResourceCustomDefaulter fills the provider config fields a MyResource
+// leaves unset, first from annotations on its namespace and then from the
+// cluster's MyResourceDefaults, so a MyResource can be as short as a
+// desiredCount and a provider.
+//
+// Defaults are applied on create only. Re-running them on update would let a
+// change to the defaults rewrite existing specs behind their owners' backs,
+// and a broken namespace annotation would then block every update, including
+// the controller's finalizer removal.
+type MyResourceCustomDefaulter struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomDefaulter = &MyResourceCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type MyResource.
+func (d *MyResourceCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	myres, ok := obj.(*devopsv1.MyResource)
+	if !ok {
+		return fmt.Errorf("expected a MyResource object but got %T", obj)
+	}
+	myresourcelog.Info("Defaulting for MyResource", "name", myres.GetName())
+
+	var defaults devopsv1.MyResourceDefaults
+	err := d.Client.Get(ctx, client.ObjectKey{Name: devopsv1.MyResourceDefaultsName}, &defaults)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get MyResourceDefaults %q: %w", devopsv1.MyResourceDefaultsName, err)
+	}
+	var namespace corev1.Namespace
+	if err := d.Client.Get(ctx, client.ObjectKey{Name: myres.Namespace}, &namespace); err != nil {
+		return fmt.Errorf("failed to get namespace %q: %w", myres.Namespace, err)
+	}
+	annotations := namespace.Annotations
+
+	spec := &myres.Spec
+	unconfigured := spec.GCPConfig == nil && spec.AWSConfig == nil && spec.AzureConfig == nil
+	switch {
+	case spec.Provider != "":
+	case !unconfigured:
+		// Leave specs with several configs for the validator to reject.
+		spec.Provider = providerOf(spec)
+	case annotations[devopsv1.DefaultProviderAnnotation] != "":
+		spec.Provider = annotations[devopsv1.DefaultProviderAnnotation]
+	default:
+		spec.Provider = defaults.Spec.Provider
+	}
+
+	fields := annotationFields(annotations, spec.Provider)
+	switch spec.Provider {
+	case cloudclients.ProviderGCP:
+		if unconfigured {
+			spec.GCPConfig = &devopsv1.GCPConfigSpec{}
+		}
+		if spec.GCPConfig != nil {
+			return fillDefaults(spec.GCPConfig, defaults.Spec.GCPConfig, fields)
+		}
+	case cloudclients.ProviderAWS:
+		if unconfigured {
+			spec.AWSConfig = &devopsv1.AWSConfigSpec{}
+		}
+		if spec.AWSConfig != nil {
+			return fillDefaults(spec.AWSConfig, defaults.Spec.AWSConfig, fields)
+		}
+	case cloudclients.ProviderAzure:
+		if unconfigured {
+			spec.AzureConfig = &devopsv1.AzureConfigSpec{}
+		}
+		if spec.AzureConfig != nil {
+			return fillDefaults(spec.AzureConfig, defaults.Spec.AzureConfig, fields)
+		}
+	}
+	return nil
+}
+
+// annotationFields returns the per-field default annotations for provider,
+// keyed by field name.
+func annotationFields(annotations map[string]string, provider string) map[string]string {
+	if provider == "" {
+		return nil
+	}
+	prefix := provider + devopsv1.DefaultsAnnotationSuffix
+	fields := make(map[string]string)
+	for key, value := range annotations {
+		if field, ok := strings.CutPrefix(key, prefix); ok {
+			fields[field] = value
+		}
+	}
+	return fields
+}
+
+// fillDefaults sets the top-level fields of config that are unset, first
+// from fields, the namespace annotations, and then from clusterDefaults, a
+// config of the same type that may be nil.
+func fillDefaults(config, clusterDefaults any, fields map[string]string) error {
+	current, err := toFieldMap(config)
+	if err != nil {
+		return err
+	}
+	jsonTypes := jsonFieldTypes(reflect.TypeOf(config).Elem())
+
+	for field, value := range fields {
+		if _, set := current[field]; set {
+			continue
+		}
+		fieldType, ok := jsonTypes[field]
+		if !ok {
+			return fmt.Errorf("namespace annotation sets unknown field %q", field)
+		}
+		if fieldType.Kind() == reflect.String {
+			current[field] = value
+			continue
+		}
+		var parsed any
+		if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+			return fmt.Errorf("namespace annotation for field %q is not valid JSON: %w", field, err)
+		}
+		current[field] = parsed
+	}
+
+	defaults, err := toFieldMap(clusterDefaults)
+	if err != nil {
+		return err
+	}
+	for field, value := range defaults {
+		if _, set := current[field]; !set {
+			current[field] = value
+		}
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("invalid defaults: %w", err)
+	}
+	return nil
+}
+
+// toFieldMap returns the JSON fields set in v.
+func toFieldMap(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]any{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	return fields, nil
+}
+
+// jsonFieldTypes maps the JSON names of the fields of struct type t to their types.
+func jsonFieldTypes(t reflect.Type) map[string]reflect.Type {
+	types := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			types[name] = field.Type
+		}
+	}
+	return types
+}
@@ -0,0 +1,307 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+	"github.com/andyzhang8/k8s-custom-controller/pkg/cloudclients"
+)
+
+// nolint:unused
+// log is for logging in this package.
+var myresourcelog = logf.Log.WithName("myresource-resource")
+
+// Formats of the cloud identifiers the webhook checks.
+var (
+	gcpProjectIDPattern      = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+	gcpZonePattern           = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+-[a-z]$`)
+	awsRegionPattern         = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]*)?-[a-z]+-[0-9]+$`)
+	awsImageIDPattern        = regexp.MustCompile(`^ami-[0-9a-f]{8,17}$`)
+	awsSubnetIDPattern       = regexp.MustCompile(`^subnet-[0-9a-f]{8,17}$`)
+	awsSecurityGroupPattern  = regexp.MustCompile(`^sg-[0-9a-f]{8,17}$`)
+	azureUUIDPattern         = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	azureResourceGroupRegexp = regexp.MustCompile(`^[-\w.()]{0,89}[-\w()]$`)
+	azureSubnetIDPattern     = regexp.MustCompile(
+		`(?i)^/subscriptions/[0-9a-f-]{36}/resourceGroups/[^/]+/providers/Microsoft\.Network/virtualNetworks/[^/]+/subnets/[^/]+$`)
+)
+
+// SetupMyResourceWebhookWithManager registers the webhook for MyResource in the manager.
+func SetupMyResourceWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&devopsv1.MyResource{}).
+		WithValidator(&MyResourceCustomValidator{}).
+		WithDefaulter(&MyResourceCustomDefaulter{Client: mgr.GetClient()}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-devops-example-com-v1-myresource,mutating=false,failurePolicy=fail,sideEffects=None,groups=devops.example.com,resources=myresources,verbs=create;update,versions=v1,name=vmyresource-v1.kb.io,admissionReviewVersions=v1
+
+// MyResourceCustomValidator rejects MyResources the controller could not act
+// on, so mistakes surface at admission rather than in status after the fact.
+type MyResourceCustomValidator struct{}
+
+var _ webhook.CustomValidator = &MyResourceCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type MyResource.
+func (v *MyResourceCustomValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	myres, ok := obj.(*devopsv1.MyResource)
+	if !ok {
+		return nil, fmt.Errorf("expected a MyResource object but got %T", obj)
+	}
+	myresourcelog.Info("Validation for MyResource upon creation", "name", myres.GetName())
+
+	return deprecationWarnings(myres), invalid(myres, validateSpec(myres))
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type MyResource.
+func (v *MyResourceCustomValidator) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	myres, ok := newObj.(*devopsv1.MyResource)
+	if !ok {
+		return nil, fmt.Errorf("expected a MyResource object for the newObj but got %T", newObj)
+	}
+	old, ok := oldObj.(*devopsv1.MyResource)
+	if !ok {
+		return nil, fmt.Errorf("expected a MyResource object for the oldObj but got %T", oldObj)
+	}
+	myresourcelog.Info("Validation for MyResource upon update", "name", myres.GetName())
+
+	// Let objects that are going away drop their finalizer whatever their spec says.
+	if !myres.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	allErrs := validateSpec(myres)
+	allErrs = append(allErrs, validateTransition(old, myres)...)
+	return deprecationWarnings(myres), invalid(myres, allErrs)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type MyResource.
+func (v *MyResourceCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// invalid wraps errs in the Invalid status error the API server expects, or returns nil.
+func invalid(myres *devopsv1.MyResource, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(devopsv1.GroupVersion.WithKind("MyResource").GroupKind(), myres.Name, errs)
+}
+
+// validateSpec checks the spec on its own.
+func validateSpec(myres *devopsv1.MyResource) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	spec := &myres.Spec
+
+	// Kept for objects stored before the CRD schema gained its minimum, which
+	// validation ratcheting still lets through on update.
+	if spec.DesiredCount < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("desiredCount"), spec.DesiredCount,
+			"must be greater than or equal to 0"))
+	}
+
+	var configured []string
+	if spec.GCPConfig != nil {
+		configured = append(configured, "gcpConfig")
+		allErrs = append(allErrs, validateGCP(spec.GCPConfig, specPath.Child("gcpConfig"))...)
+	}
+	if spec.AWSConfig != nil {
+		configured = append(configured, "awsConfig")
+		allErrs = append(allErrs, validateAWS(spec.AWSConfig, specPath.Child("awsConfig"))...)
+	}
+	if spec.AzureConfig != nil {
+		configured = append(configured, "azureConfig")
+		allErrs = append(allErrs, validateAzure(spec.AzureConfig, specPath.Child("azureConfig"))...)
+	}
+	switch len(configured) {
+	case 0:
+		allErrs = append(allErrs, field.Required(specPath,
+			"exactly one of gcpConfig, awsConfig and azureConfig must be set"))
+	case 1:
+		if spec.Provider != "" && spec.Provider != providerOf(spec) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("provider"), spec.Provider,
+				fmt.Sprintf("does not match the configured %s", configured[0])))
+		}
+	default:
+		allErrs = append(allErrs, field.Forbidden(specPath,
+			fmt.Sprintf("exactly one of gcpConfig, awsConfig and azureConfig must be set, got %s",
+				strings.Join(configured, ", "))))
+	}
+
+	if bootstrap := spec.Bootstrap; bootstrap != nil {
+		sources := 0
+		for _, set := range []bool{bootstrap.Script != "", bootstrap.ConfigMapRef != nil, bootstrap.SecretRef != nil} {
+			if set {
+				sources++
+			}
+		}
+		if sources > 1 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("bootstrap"),
+				"only one of script, configMapRef and secretRef may be set"))
+		}
+	}
+	return allErrs
+}
+
+func validateGCP(gcp *devopsv1.GCPConfigSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	allErrs = append(allErrs, requiredMatch(path.Child("projectID"), gcp.ProjectID, gcpProjectIDPattern)...)
+	allErrs = append(allErrs, requiredMatch(path.Child("zone"), gcp.Zone, gcpZonePattern)...)
+	if gcp.Region != "" && gcp.Zone != "" && !strings.HasPrefix(gcp.Zone, gcp.Region+"-") {
+		allErrs = append(allErrs, field.Invalid(path.Child("zone"), gcp.Zone,
+			fmt.Sprintf("must be a zone in region %q", gcp.Region)))
+	}
+	if sa := gcp.ServiceAccount; sa != nil && sa.Email == "" {
+		allErrs = append(allErrs, field.Required(path.Child("serviceAccount", "email"), ""))
+	}
+	if vm := gcp.ShieldedVM; vm != nil && vm.IntegrityMonitoring && !vm.VTPM {
+		allErrs = append(allErrs, field.Invalid(path.Child("shieldedVM", "integrityMonitoring"), true,
+			"requires vTPM"))
+	}
+	return allErrs
+}
+
+func validateAWS(aws *devopsv1.AWSConfigSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	allErrs = append(allErrs, requiredMatch(path.Child("region"), aws.Region, awsRegionPattern)...)
+	if aws.InstanceType == "" {
+		allErrs = append(allErrs, field.Required(path.Child("instanceType"), ""))
+	}
+	if aws.ImageID != "" && !awsImageIDPattern.MatchString(aws.ImageID) {
+		allErrs = append(allErrs, field.Invalid(path.Child("imageID"), aws.ImageID, "must be an AMI ID such as ami-0123456789abcdef0"))
+	}
+	for i, id := range aws.SubnetIDs {
+		if !awsSubnetIDPattern.MatchString(id) {
+			allErrs = append(allErrs, field.Invalid(path.Child("subnetIDs").Index(i), id, "must be a subnet ID such as subnet-0123456789abcdef0"))
+		}
+	}
+	for i, id := range aws.SecurityGroupIDs {
+		if !awsSecurityGroupPattern.MatchString(id) {
+			allErrs = append(allErrs, field.Invalid(path.Child("securityGroupIDs").Index(i), id, "must be a security group ID such as sg-0123456789abcdef0"))
+		}
+	}
+	if placement := aws.Placement; placement != nil && placement.AvailabilityZone != "" && aws.Region != "" &&
+		!strings.HasPrefix(placement.AvailabilityZone, aws.Region) {
+		allErrs = append(allErrs, field.Invalid(path.Child("placement", "availabilityZone"), placement.AvailabilityZone,
+			fmt.Sprintf("must be a zone in region %q", aws.Region)))
+	}
+	return allErrs
+}
+
+func validateAzure(azure *devopsv1.AzureConfigSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	allErrs = append(allErrs, requiredMatch(path.Child("subscriptionID"), azure.SubscriptionID, azureUUIDPattern)...)
+	allErrs = append(allErrs, requiredMatch(path.Child("resourceGroup"), azure.ResourceGroup, azureResourceGroupRegexp)...)
+	if azure.SubnetID != "" && !azureSubnetIDPattern.MatchString(azure.SubnetID) {
+		allErrs = append(allErrs, field.Invalid(path.Child("subnetID"), azure.SubnetID,
+			"must be a subnet resource ID: /subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.Network/virtualNetworks/<vnet>/subnets/<subnet>"))
+	}
+	if azure.SubnetID == "" && azure.NetworkInterfaceID == "" {
+		allErrs = append(allErrs, field.Required(path.Child("subnetID"), ""))
+	}
+	return allErrs
+}
+
+// requiredMatch reports value as missing if it is empty and as invalid if it does not match pattern.
+func requiredMatch(path *field.Path, value string, pattern *regexp.Regexp) field.ErrorList {
+	switch {
+	case value == "":
+		return field.ErrorList{field.Required(path, "")}
+	case !pattern.MatchString(value):
+		return field.ErrorList{field.Invalid(path, value, fmt.Sprintf("must match %s", pattern))}
+	default:
+		return nil
+	}
+}
+
+// validateTransition rejects updates that would strand the instances of a
+// live MyResource: switching cloud, or moving to another region, zone or
+// resource group, where the controller could no longer find them. The project
+// and subscription are immutable once set whether or not instances exist,
+// matching the CRD's CEL rules.
+func validateTransition(old, myres *devopsv1.MyResource) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	setOnce := func(path *field.Path, oldValue, newValue string) {
+		if oldValue != "" && oldValue != newValue {
+			allErrs = append(allErrs, field.Forbidden(path, "is immutable once set"))
+		}
+	}
+	if old.Spec.GCPConfig != nil && myres.Spec.GCPConfig != nil {
+		setOnce(specPath.Child("gcpConfig", "projectID"), old.Spec.GCPConfig.ProjectID, myres.Spec.GCPConfig.ProjectID)
+	}
+	if old.Spec.AzureConfig != nil && myres.Spec.AzureConfig != nil {
+		setOnce(specPath.Child("azureConfig", "subscriptionID"),
+			old.Spec.AzureConfig.SubscriptionID, myres.Spec.AzureConfig.SubscriptionID)
+	}
+	if !live(old) {
+		return allErrs
+	}
+
+	oldProvider, newProvider := providerOf(&old.Spec), providerOf(&myres.Spec)
+	if oldProvider != newProvider {
+		return append(allErrs, field.Forbidden(specPath,
+			fmt.Sprintf("cannot switch from %s to %s while instances exist; scale to 0 first", oldProvider, newProvider)))
+	}
+
+	immutable := func(path *field.Path, oldValue, newValue string) {
+		if oldValue != newValue {
+			allErrs = append(allErrs, field.Forbidden(path, "cannot be changed while instances exist; scale to 0 first"))
+		}
+	}
+	switch newProvider {
+	case cloudclients.ProviderGCP:
+		immutable(specPath.Child("gcpConfig", "zone"), old.Spec.GCPConfig.Zone, myres.Spec.GCPConfig.Zone)
+	case cloudclients.ProviderAWS:
+		immutable(specPath.Child("awsConfig", "region"), old.Spec.AWSConfig.Region, myres.Spec.AWSConfig.Region)
+	case cloudclients.ProviderAzure:
+		immutable(specPath.Child("azureConfig", "resourceGroup"),
+			old.Spec.AzureConfig.ResourceGroup, myres.Spec.AzureConfig.ResourceGroup)
+	}
+	return allErrs
+}
+
+// live reports whether the MyResource has, or may have, cloud instances.
+func live(myres *devopsv1.MyResource) bool {
+	return myres.Status.CurrentCount > 0 || len(myres.Status.Instances) > 0 || len(myres.Status.PendingCreates) > 0
+}
+
+// providerOf returns the name of the single provider the spec configures, or "".
+func providerOf(spec *devopsv1.MyResourceSpec) string {
+	switch {
+	case spec.GCPConfig != nil && spec.AWSConfig == nil && spec.AzureConfig == nil:
+		return cloudclients.ProviderGCP
+	case spec.AWSConfig != nil && spec.GCPConfig == nil && spec.AzureConfig == nil:
+		return cloudclients.ProviderAWS
+	case spec.AzureConfig != nil && spec.GCPConfig == nil && spec.AWSConfig == nil:
+		return cloudclients.ProviderAzure
+	default:
+		return ""
+	}
+}
+
+// deprecationWarnings returns a warning for each deprecated field the spec sets.
+func deprecationWarnings(myres *devopsv1.MyResource) admission.Warnings {
+	var warnings admission.Warnings
+	if aws := myres.Spec.AWSConfig; aws != nil {
+		if aws.NetworkInterfaceID != "" || aws.SubscriptionID != "" || aws.ResourceGroup != "" {
+			warnings = append(warnings,
+				"spec.awsConfig.networkInterfaceID, subscriptionID and resourceGroup are ignored and will be removed")
+		}
+	}
+	if azure := myres.Spec.AzureConfig; azure != nil && azure.NetworkInterfaceID != "" {
+		warnings = append(warnings, "spec.azureConfig.networkInterfaceID is deprecated; use subnetID")
+	}
+	return warnings
+}
@@ -0,0 +1,265 @@
+package v1
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	structuraldefaulting "k8s.io/apiextensions-apiserver/pkg/apiserver/schema/defaulting"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+)
+
+var _ = Describe("MyResource Webhook", func() {
+	ctx := context.Background()
+
+	var (
+		obj       *devopsv1.MyResource
+		oldObj    *devopsv1.MyResource
+		validator MyResourceCustomValidator
+	)
+
+	BeforeEach(func() {
+		obj = &devopsv1.MyResource{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: devopsv1.MyResourceSpec{
+				DesiredCount: 2,
+				GCPConfig: &devopsv1.GCPConfigSpec{
+					ProjectID:   "test-project",
+					Zone:        "us-central1-a",
+					MachineType: "e2-medium",
+				},
+			},
+		}
+		oldObj = obj.DeepCopy()
+		validator = MyResourceCustomValidator{}
+	})
+
+	Context("When creating a MyResource under the validating webhook", func() {
+		It("should admit a valid spec", func() {
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("should deny a spec without a cloud config", func() {
+			obj.Spec.GCPConfig = nil
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("exactly one of gcpConfig, awsConfig and azureConfig"))
+		})
+
+		It("should deny a spec with several cloud configs", func() {
+			obj.Spec.AWSConfig = &devopsv1.AWSConfigSpec{Region: "us-east-1", InstanceType: "t3.micro"}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("got gcpConfig, awsConfig")))
+		})
+
+		It("should deny missing and malformed provider fields", func() {
+			obj.Spec.GCPConfig = nil
+			obj.Spec.AzureConfig = &devopsv1.AzureConfigSpec{
+				SubscriptionID: "not-a-uuid",
+				SubnetID:       "subnet-1",
+			}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.azureConfig.subscriptionID: Invalid value"))
+			Expect(err.Error()).To(ContainSubstring("spec.azureConfig.resourceGroup: Required value"))
+			Expect(err.Error()).To(ContainSubstring("spec.azureConfig.subnetID: Invalid value"))
+		})
+
+		It("should deny malformed AWS IDs", func() {
+			obj.Spec.GCPConfig = nil
+			obj.Spec.AWSConfig = &devopsv1.AWSConfigSpec{
+				Region:       "us-east-1",
+				InstanceType: "t3.micro",
+				ImageID:      "ubuntu",
+				SubnetIDs:    []string{"subnet-0123456789abcdef0", "vpc-1"},
+			}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.awsConfig.imageID"))
+			Expect(err.Error()).To(ContainSubstring("spec.awsConfig.subnetIDs[1]"))
+			Expect(err.Error()).NotTo(ContainSubstring("subnetIDs[0]"))
+		})
+
+		It("should warn about deprecated fields", func() {
+			obj.Spec.GCPConfig = nil
+			obj.Spec.AzureConfig = &devopsv1.AzureConfigSpec{
+				SubscriptionID:     "00000000-0000-0000-0000-000000000000",
+				ResourceGroup:      "rg-web",
+				NetworkInterfaceID: "/subscriptions/x/nic",
+			}
+			warnings, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring("use subnetID")))
+		})
+	})
+
+	Context("When creating a MyResource under the defaulting webhook", func() {
+		var defaulter MyResourceCustomDefaulter
+		enabled, disabled := true, false
+
+		BeforeEach(func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(devopsv1.AddToScheme(scheme)).To(Succeed())
+			defaulter = MyResourceCustomDefaulter{Client: clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name: "default",
+					Annotations: map[string]string{
+						"gcp.defaults.devops.example.com/zone":       "europe-west1-b",
+						"aws.defaults.devops.example.com/rootVolume": `{"sizeGiB": 30}`,
+						"gcp.defaults.devops.example.com/externalIP": "false",
+					},
+				}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:        "team-aws",
+					Annotations: map[string]string{devopsv1.DefaultProviderAnnotation: "aws"},
+				}},
+				&devopsv1.MyResourceDefaults{
+					ObjectMeta: metav1.ObjectMeta{Name: devopsv1.MyResourceDefaultsName},
+					Spec: devopsv1.MyResourceDefaultsSpec{
+						Provider: "gcp",
+						GCPConfig: &devopsv1.GCPConfigSpec{
+							ProjectID:   "shared-project",
+							Zone:        "us-central1-a",
+							MachineType: "e2-small",
+						},
+						AWSConfig: &devopsv1.AWSConfigSpec{
+							Region:        "eu-west-1",
+							InstanceType:  "t3.small",
+							RequireIMDSv2: &enabled,
+						},
+					},
+				},
+			).Build()}
+		})
+
+		It("should build the config for the named provider from the defaults", func() {
+			obj.Spec.GCPConfig = nil
+			obj.Spec.Provider = "gcp"
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.GCPConfig).To(Equal(&devopsv1.GCPConfigSpec{
+				ProjectID:   "shared-project",
+				Zone:        "europe-west1-b",
+				MachineType: "e2-small",
+				ExternalIP:  &disabled,
+			}))
+		})
+
+		It("should keep fields the spec sets and record the provider", func() {
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Provider).To(Equal("gcp"))
+			Expect(obj.Spec.GCPConfig.ProjectID).To(Equal("test-project"))
+			Expect(obj.Spec.GCPConfig.Zone).To(Equal("us-central1-a"))
+			Expect(obj.Spec.GCPConfig.MachineType).To(Equal("e2-medium"))
+		})
+
+		It("should apply defaults to fields the CRD schema leaves unset", func() {
+			// The API server applies the schema's defaults before calling the webhook.
+			obj = withSchemaDefaults(obj)
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.GCPConfig.ExternalIP).To(Equal(&disabled))
+		})
+
+		It("should take the provider from the namespace", func() {
+			obj.Namespace = "team-aws"
+			obj.Spec.GCPConfig = nil
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Provider).To(Equal("aws"))
+			Expect(obj.Spec.AWSConfig).To(Equal(&devopsv1.AWSConfigSpec{
+				Region:        "eu-west-1",
+				InstanceType:  "t3.small",
+				RequireIMDSv2: &enabled,
+			}))
+		})
+
+		It("should keep booleans the spec explicitly sets to false", func() {
+			obj.Spec.GCPConfig = nil
+			obj.Spec.AWSConfig = &devopsv1.AWSConfigSpec{RequireIMDSv2: &disabled}
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.AWSConfig.RequireIMDSv2).To(Equal(&disabled))
+			Expect(obj.Spec.AWSConfig.Region).To(Equal("eu-west-1"))
+		})
+
+		It("should parse non-string fields from annotations", func() {
+			obj.Spec.GCPConfig = nil
+			obj.Spec.Provider = "aws"
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.AWSConfig.RootVolume).To(Equal(&devopsv1.AWSRootVolume{SizeGiB: 30}))
+		})
+	})
+
+	Context("When updating a MyResource under the validating webhook", func() {
+		It("should deny switching provider while instances exist", func() {
+			oldObj.Status.CurrentCount = 2
+			obj.Spec.GCPConfig = nil
+			obj.Spec.AWSConfig = &devopsv1.AWSConfigSpec{Region: "us-east-1", InstanceType: "t3.micro"}
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(MatchError(ContainSubstring("cannot switch from gcp to aws")))
+		})
+
+		It("should allow switching provider once scaled to zero", func() {
+			obj.Spec.GCPConfig = nil
+			obj.Spec.AWSConfig = &devopsv1.AWSConfigSpec{Region: "us-east-1", InstanceType: "t3.micro"}
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("should deny moving a live resource to another zone", func() {
+			oldObj.Status.Instances = []devopsv1.InstanceStatus{{Provider: "gcp", ID: "i-1"}}
+			obj.Spec.GCPConfig.Zone = "us-central1-b"
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.gcpConfig.zone: Forbidden")))
+		})
+
+		It("should deny changing the project even when scaled to zero", func() {
+			obj.Spec.GCPConfig.ProjectID = "other-project"
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.gcpConfig.projectID: Forbidden: is immutable once set")))
+		})
+
+		It("should allow setting a project that was unset", func() {
+			oldObj.Spec.GCPConfig.ProjectID = ""
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("should admit updates to resources being deleted", func() {
+			now := metav1.Now()
+			obj.DeletionTimestamp = &now
+			obj.Spec.GCPConfig = nil
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+		})
+	})
+})
+
+// withSchemaDefaults returns obj with the defaults of the generated CRD schema
+// applied, as the API server does before calling the mutating webhook.
+func withSchemaDefaults(obj *devopsv1.MyResource) *devopsv1.MyResource {
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "config", "crd", "bases", "devops.example.com_myresources.yaml"))
+	Expect(err).NotTo(HaveOccurred())
+	var crd apiextensionsv1.CustomResourceDefinition
+	Expect(yaml.Unmarshal(data, &crd)).To(Succeed())
+	var props apiextensions.JSONSchemaProps
+	Expect(apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(
+		crd.Spec.Versions[0].Schema.OpenAPIV3Schema, &props, nil)).To(Succeed())
+	schema, err := structuralschema.NewStructural(&props)
+	Expect(err).NotTo(HaveOccurred())
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	Expect(err).NotTo(HaveOccurred())
+	structuraldefaulting.Default(content, schema)
+	defaulted := &devopsv1.MyResource{}
+	Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(content, defaulted)).To(Succeed())
+	return defaulted
+}
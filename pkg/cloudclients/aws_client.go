@@ -2,126 +2,579 @@ package cloudclients
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
-	"time"
+	"net/http"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 
 	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
 )
 
-// UpdateAWSInstances ensures the running EC2 instances match 'desiredCount'.
-func UpdateAWSInstances(
-	ctx context.Context,
-	config devopsv1.AWSConfigSpec,
-	currentCount int,
-	desiredCount int,
-) error {
-	// 1. Init EC2 client.
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(config.Region),
-	})
+// ec2KeyPairNameMaxLength is the longest key pair name EC2 accepts.
+const ec2KeyPairNameMaxLength = 255
+
+// awsProvider manages EC2 instances for a single MyResource.
+type awsProvider struct {
+	ec2Svc *ec2.EC2
+	config devopsv1.AWSConfigSpec
+	owner  Owner
+}
+
+// NewAWSProvider builds a Provider backed by EC2. It authenticates with the
+// access key in creds, or the default credential chain if creds is nil.
+func NewAWSProvider(ctx context.Context, res *devopsv1.MyResource, creds Credentials) (Provider, error) {
+	if res.Spec.AWSConfig == nil {
+		return nil, fmt.Errorf("awsConfig is not set")
+	}
+
+	cfg := &aws.Config{
+		Region: aws.String(res.Spec.AWSConfig.Region),
+	}
+	if creds != nil {
+		accessKeyID, err := creds.required(AWSAccessKeyIDKey)
+		if err != nil {
+			return nil, err
+		}
+		secretAccessKey, err := creds.required(AWSSecretAccessKeyKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Credentials = credentials.NewStaticCredentials(
+			string(accessKeyID), string(secretAccessKey), string(creds[AWSSessionTokenKey]))
+	}
+
+	sess, err := session.NewSession(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to create AWS session: %w", err)
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
 	}
-	ec2Svc := ec2.New(sess)
 
-	// 2. Calculate how many instances to add or remove.
-	diff := desiredCount - currentCount
-	if diff > 0 {
-		for i := 0; i < diff; i++ {
-			if err := createEC2Instance(ctx, ec2Svc, config); err != nil {
-				return err
-			}
+	return &awsProvider{ec2Svc: ec2.New(sess), config: *res.Spec.AWSConfig, owner: OwnerOf(res)}, nil
+}
+
+func (p *awsProvider) Name() string {
+	return ProviderAWS
+}
+
+// ListInstances returns the EC2 instances tagged as owned by the MyResource.
+func (p *awsProvider) ListInstances(ctx context.Context) ([]Instance, error) {
+	instances, err := p.listByIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var owned []Instance
+	for _, instance := range instances {
+		if ownedBy(instance.Tags, p.owner.Tags()) {
+			owned = append(owned, instance)
 		}
-	} else if diff < 0 {
-		toDelete := -diff
-		if err := deleteEC2Instances(ctx, ec2Svc, config, toDelete); err != nil {
-			return err
+	}
+	return owned, nil
+}
+
+// ListOrphans returns the EC2 instances released by an earlier MyResource with the same namespace and name.
+func (p *awsProvider) ListOrphans(ctx context.Context) ([]Instance, error) {
+	instances, err := p.listByIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []Instance
+	for _, instance := range instances {
+		if orphanedBy(instance.Tags, p.owner.identityTags()) {
+			orphans = append(orphans, instance)
 		}
 	}
+	return orphans, nil
+}
 
-	return nil
+// listByIdentity returns the live EC2 instances tagged with the MyResource namespace and name.
+func (p *awsProvider) listByIdentity(ctx context.Context) ([]Instance, error) {
+	var instances []Instance
+	input := &ec2.DescribeInstancesInput{
+		// Terminated instances stay visible for about an hour; skip them.
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{"pending", "running", "shutting-down", "stopping", "stopped"}),
+			},
+		},
+	}
+	for key, value := range p.owner.identityTags() {
+		input.Filters = append(input.Filters, &ec2.Filter{
+			Name:   aws.String("tag:" + key),
+			Values: aws.StringSlice([]string{value}),
+		})
+	}
+	err := p.ec2Svc.DescribeInstancesPagesWithContext(ctx, input,
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					instances = append(instances, toAWSInstance(instance))
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe EC2 instances: %w", err)
+	}
+	return instances, nil
 }
 
-// createEC2Instance creates a single EC2 instance with the specified config.
-func createEC2Instance(
-	ctx context.Context,
-	ec2Svc *ec2.EC2,
-	config devopsv1.AWSConfigSpec,
-) error {
-	rand.Seed(time.Now().UnixNano())
-	instanceName := fmt.Sprintf("myresource-%d", rand.Intn(1000000))
+// CreateInstance creates a single EC2 instance with the specified config.
+func (p *awsProvider) CreateInstance(ctx context.Context, req InstanceRequest) (*Instance, error) {
+	instanceName := req.Name
 
-	runResult, err := ec2Svc.RunInstances(&ec2.RunInstancesInput{
-		ImageId:      aws.String("ami-0abcdef1234567890"),
-		InstanceType: aws.String(config.InstanceType),
+	imageID := req.Image
+	if imageID == "" {
+		var err error
+		if imageID, err = p.ResolveImage(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	input := &ec2.RunInstancesInput{
+		ImageId:      aws.String(imageID),
+		InstanceType: aws.String(p.config.InstanceType),
 		MinCount:     aws.Int64(1),
 		MaxCount:     aws.Int64(1),
 		TagSpecifications: []*ec2.TagSpecification{
 			{
 				ResourceType: aws.String("instance"),
-				Tags: []*ec2.Tag{
-					{
-						Key:   aws.String("Name"),
-						Value: aws.String(instanceName),
-					},
-				},
+				Tags:         p.instanceTags(instanceName, req.Tags),
 			},
 		},
-	})
+	}
+	if req.ClientToken != "" {
+		// EC2 returns the original reservation when a request is retried with the same token.
+		input.ClientToken = aws.String(req.ClientToken)
+	}
+	if req.UserData != "" {
+		input.UserData = aws.String(base64.StdEncoding.EncodeToString([]byte(req.UserData)))
+	}
+	if err := p.configureLaunch(ctx, input, req.Index); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.config.KeyName != "":
+		input.KeyName = aws.String(p.config.KeyName)
+	case aws.BoolValue(p.config.ImportKeyPair) && len(req.SSHPublicKeys) > 0:
+		if err := p.ensureKeyPair(ctx, req.SSHPublicKeys[0]); err != nil {
+			return nil, err
+		}
+		input.KeyName = aws.String(p.keyPairName())
+	}
+
+	runResult, err := p.ec2Svc.RunInstancesWithContext(ctx, input)
 	if err != nil {
-		return fmt.Errorf("failed to create EC2 instance: %w", err)
+		return nil, fmt.Errorf("failed to create EC2 instance: %w", err)
 	}
 
 	log.Printf("[AWS] Created EC2 instance: %s", *runResult.Instances[0].InstanceId)
+	instance := toAWSInstance(runResult.Instances[0])
+	return &instance, nil
+}
+
+// configureLaunch applies the network, IAM, storage, metadata and placement
+// settings of the spec to input. index selects the subnet.
+func (p *awsProvider) configureLaunch(ctx context.Context, input *ec2.RunInstancesInput, index int) error {
+	if len(p.config.SubnetIDs) > 0 {
+		input.SubnetId = aws.String(p.config.SubnetIDs[index%len(p.config.SubnetIDs)])
+	}
+	if len(p.config.SecurityGroupIDs) > 0 {
+		input.SecurityGroupIds = aws.StringSlice(p.config.SecurityGroupIDs)
+	}
+
+	if profile := p.config.IAMInstanceProfile; profile != "" {
+		if strings.HasPrefix(profile, "arn:") {
+			input.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{Arn: aws.String(profile)}
+		} else {
+			input.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{Name: aws.String(profile)}
+		}
+	}
+
+	if volume := p.config.RootVolume; volume != nil {
+		// The mapping must name the AMI's root device to override it.
+		out, err := p.ec2Svc.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+			ImageIds: []*string{input.ImageId},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to describe AMI %s: %w", aws.StringValue(input.ImageId), err)
+		}
+		if len(out.Images) == 0 {
+			return fmt.Errorf("AMI %s not found", aws.StringValue(input.ImageId))
+		}
+
+		ebs := &ec2.EbsBlockDevice{DeleteOnTermination: aws.Bool(true)}
+		if volume.SizeGiB > 0 {
+			ebs.VolumeSize = aws.Int64(volume.SizeGiB)
+		}
+		if volume.Type != "" {
+			ebs.VolumeType = aws.String(volume.Type)
+		}
+		// A KMS key only applies to an encrypted volume, so asking for one encrypts it.
+		if volume.Encrypted || volume.KMSKeyID != "" {
+			ebs.Encrypted = aws.Bool(true)
+		}
+		if volume.KMSKeyID != "" {
+			ebs.KmsKeyId = aws.String(volume.KMSKeyID)
+		}
+		input.BlockDeviceMappings = []*ec2.BlockDeviceMapping{
+			{DeviceName: out.Images[0].RootDeviceName, Ebs: ebs},
+		}
+	}
+
+	if aws.BoolValue(p.config.RequireIMDSv2) {
+		input.MetadataOptions = &ec2.InstanceMetadataOptionsRequest{
+			HttpEndpoint: aws.String(ec2.InstanceMetadataEndpointStateEnabled),
+			HttpTokens:   aws.String(ec2.HttpTokensStateRequired),
+		}
+	}
+
+	if placement := p.config.Placement; placement != nil {
+		input.Placement = &ec2.Placement{}
+		if placement.AvailabilityZone != "" {
+			input.Placement.AvailabilityZone = aws.String(placement.AvailabilityZone)
+		}
+		if placement.GroupName != "" {
+			input.Placement.GroupName = aws.String(placement.GroupName)
+		}
+		if placement.Tenancy != "" {
+			input.Placement.Tenancy = aws.String(placement.Tenancy)
+		}
+	}
 	return nil
 }
 
-// deleteEC2Instances removes 'numToDelete' of your operator-managed instances.
-func deleteEC2Instances(
-	ctx context.Context,
-	ec2Svc *ec2.EC2,
-	config devopsv1.AWSConfigSpec,
-	numToDelete int,
-) error {
-	describeResult, err := ec2Svc.DescribeInstances(&ec2.DescribeInstancesInput{})
+// ImageSource returns the AMI ID, or the owner and name filter of the AMI lookup.
+func (p *awsProvider) ImageSource() string {
+	if p.config.ImageID != "" || p.config.ImageLookup == nil {
+		return p.config.ImageID
+	}
+	return fmt.Sprintf("owners=%s,name=%s",
+		strings.Join(p.config.ImageLookup.Owners, ","), p.config.ImageLookup.Name)
+}
+
+// ResolveImage returns the configured AMI ID, or the newest available AMI matching the lookup.
+func (p *awsProvider) ResolveImage(ctx context.Context) (string, error) {
+	if p.config.ImageID != "" {
+		return p.config.ImageID, nil
+	}
+	lookup := p.config.ImageLookup
+	if lookup == nil {
+		return "", fmt.Errorf("awsConfig must set imageID or imageLookup")
+	}
+
+	out, err := p.ec2Svc.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+		Owners: aws.StringSlice(lookup.Owners),
+		Filters: []*ec2.Filter{
+			{Name: aws.String("name"), Values: aws.StringSlice([]string{lookup.Name})},
+			{Name: aws.String("state"), Values: aws.StringSlice([]string{ec2.ImageStateAvailable})},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up AMI %q: %w", lookup.Name, err)
+	}
+
+	var newest *ec2.Image
+	for _, image := range out.Images {
+		// CreationDate is ISO 8601, so it sorts lexically.
+		if newest == nil || aws.StringValue(image.CreationDate) > aws.StringValue(newest.CreationDate) {
+			newest = image
+		}
+	}
+	if newest == nil {
+		return "", fmt.Errorf("no available AMI matches owners %v and name %q", lookup.Owners, lookup.Name)
+	}
+	log.Printf("[AWS] Resolved AMI lookup %q to %s", lookup.Name, aws.StringValue(newest.ImageId))
+	return aws.StringValue(newest.ImageId), nil
+}
+
+// DeleteInstance terminates the EC2 instance with the given ID if it is owned by the MyResource.
+func (p *awsProvider) DeleteInstance(ctx context.Context, id string) error {
+	instance, err := p.DescribeInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ownedBy(instance.Tags, p.owner.Tags()) {
+		return fmt.Errorf("refusing to terminate EC2 instance %s: %w", id, ErrNotOwned)
+	}
+
+	_, err = p.ec2Svc.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: []*string{aws.String(id)},
+	})
 	if err != nil {
-		return fmt.Errorf("failed to describe EC2 instances: %w", err)
+		return fmt.Errorf("failed to terminate instance %s: %w", id, err)
 	}
+	log.Printf("[AWS] Terminated EC2 instance: %s", id)
+	return nil
+}
 
-	var candidateInstances []string
-	for _, reservation := range describeResult.Reservations {
+// DescribeInstance returns the EC2 instance with the given ID.
+func (p *awsProvider) DescribeInstance(ctx context.Context, id string) (*Instance, error) {
+	out, err := p.ec2Svc.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(id)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe EC2 instance %s: %w", id, err)
+	}
+	for _, reservation := range out.Reservations {
 		for _, instance := range reservation.Instances {
-			for _, tag := range instance.Tags {
-				if *tag.Key == "Name" && len(*tag.Value) >= 11 && (*tag.Value)[:11] == "myresource-" {
-					candidateInstances = append(candidateInstances, *instance.InstanceId)
-				}
-			}
+			result := toAWSInstance(instance)
+			return &result, nil
 		}
 	}
+	return nil, fmt.Errorf("EC2 instance %s not found", id)
+}
+
+// keyPairName returns the name of the key pair imported for the MyResource.
+// It includes the UID because a key pair left behind by the Retain or Orphan
+// policy still belongs to the earlier MyResource of the same name. The
+// namespace and name are shortened as needed to keep the UID within EC2's
+// limit on key pair names.
+func (p *awsProvider) keyPairName() string {
+	suffix := "-" + p.owner.UID
+	name := fmt.Sprintf("myresource-%s-%s", p.owner.Namespace, p.owner.Name)
+	if limit := ec2KeyPairNameMaxLength - len(suffix); len(name) > limit {
+		name = name[:limit]
+	}
+	return name + suffix
+}
+
+// describeKeyPair returns the imported key pair, or nil if it does not exist.
+func (p *awsProvider) describeKeyPair(ctx context.Context) (*ec2.KeyPairInfo, error) {
+	out, err := p.ec2Svc.DescribeKeyPairsWithContext(ctx, &ec2.DescribeKeyPairsInput{
+		KeyNames:         aws.StringSlice([]string{p.keyPairName()}),
+		IncludePublicKey: aws.Bool(true),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidKeyPair.NotFound" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe key pair %s: %w", p.keyPairName(), err)
+	}
+	if len(out.KeyPairs) == 0 {
+		return nil, nil
+	}
+	return out.KeyPairs[0], nil
+}
+
+// ensureKeyPair imports publicKey as the MyResource's key pair, replacing a
+// previously imported key that differs.
+func (p *awsProvider) ensureKeyPair(ctx context.Context, publicKey string) error {
+	existing, err := p.describeKeyPair(ctx)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if !ownedBy(ec2TagMap(existing.Tags), p.owner.Tags()) {
+			return fmt.Errorf("refusing to replace key pair %s: %w", p.keyPairName(), ErrNotOwned)
+		}
+		// EC2 may append a comment to the stored key, so compare the key material only.
+		if sshKeyMaterial(aws.StringValue(existing.PublicKey)) == sshKeyMaterial(publicKey) {
+			return nil
+		}
+		if err := p.deleteKeyPair(ctx); err != nil {
+			return err
+		}
+	}
+
+	var tags []*ec2.Tag
+	for key, value := range p.owner.Tags() {
+		tags = append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	_, err = p.ec2Svc.ImportKeyPairWithContext(ctx, &ec2.ImportKeyPairInput{
+		KeyName:           aws.String(p.keyPairName()),
+		PublicKeyMaterial: []byte(publicKey),
+		TagSpecifications: []*ec2.TagSpecification{
+			{ResourceType: aws.String(ec2.ResourceTypeKeyPair), Tags: tags},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import key pair %s: %w", p.keyPairName(), err)
+	}
+	log.Printf("[AWS] Imported key pair: %s", p.keyPairName())
+	return nil
+}
+
+func (p *awsProvider) deleteKeyPair(ctx context.Context) error {
+	_, err := p.ec2Svc.DeleteKeyPairWithContext(ctx, &ec2.DeleteKeyPairInput{
+		KeyName: aws.String(p.keyPairName()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete key pair %s: %w", p.keyPairName(), err)
+	}
+	log.Printf("[AWS] Deleted key pair: %s", p.keyPairName())
+	return nil
+}
 
-	if len(candidateInstances) < numToDelete {
-		numToDelete = len(candidateInstances)
+// Cleanup deletes the key pair imported for the MyResource, if any.
+func (p *awsProvider) Cleanup(ctx context.Context) error {
+	existing, err := p.describeKeyPair(ctx)
+	if err != nil || existing == nil {
+		return err
+	}
+	if !ownedBy(ec2TagMap(existing.Tags), p.owner.Tags()) {
+		// Imported by an earlier MyResource of the same name, or by hand.
+		return nil
 	}
+	return p.deleteKeyPair(ctx)
+}
 
-	toDeleteList := candidateInstances[:numToDelete]
+// instanceTags returns the tags written on a new EC2 instance: the Name tag,
+// the requested tags and the ownership tags.
+func (p *awsProvider) instanceTags(instanceName string, requested map[string]string) []*ec2.Tag {
+	merged := p.NormalizeTags(requested)
+	for key, value := range p.owner.Tags() {
+		merged[key] = value
+	}
+	tags := []*ec2.Tag{
+		{
+			Key:   aws.String("Name"),
+			Value: aws.String(instanceName),
+		},
+	}
+	for _, key := range sortedKeys(merged) {
+		tags = append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(merged[key])})
+	}
+	return tags
+}
 
-	log.Printf("[AWS] Deleting %d instance(s): %v", numToDelete, toDeleteList)
+// NormalizeName rewrites name to fit the limits of an EC2 Name tag value.
+func (p *awsProvider) NormalizeName(name string) string {
+	return truncate(replaceInvalid(name, ec2TagRune), ec2TagValueMaxLength)
+}
 
-	for _, instanceID := range toDeleteList {
-		_, err := ec2Svc.TerminateInstances(&ec2.TerminateInstancesInput{
-			InstanceIds: []*string{aws.String(instanceID)},
+// NormalizeTags rewrites tags to fit EC2's character and length limits.
+func (p *awsProvider) NormalizeTags(tags map[string]string) map[string]string {
+	return ec2Tags(tags)
+}
+
+// UpdateTags creates or overwrites the tags in set and deletes the tags in remove.
+func (p *awsProvider) UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error {
+	if len(set) > 0 {
+		var tags []*ec2.Tag
+		for key, value := range set {
+			tags = append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+		_, err := p.ec2Svc.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+			Resources: []*string{aws.String(id)},
+			Tags:      tags,
 		})
 		if err != nil {
-			return fmt.Errorf("failed to terminate instance %s: %w", instanceID, err)
+			return fmt.Errorf("failed to tag EC2 instance %s: %w", id, err)
 		}
-		log.Printf("[AWS] Terminated EC2 instance: %s", instanceID)
 	}
 
+	if len(remove) > 0 {
+		var tags []*ec2.Tag
+		for _, key := range remove {
+			tags = append(tags, &ec2.Tag{Key: aws.String(key)})
+		}
+		_, err := p.ec2Svc.DeleteTagsWithContext(ctx, &ec2.DeleteTagsInput{
+			Resources: []*string{aws.String(id)},
+			Tags:      tags,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to untag EC2 instance %s: %w", id, err)
+		}
+	}
+
+	log.Printf("[AWS] Updated tags on EC2 instance: %s", id)
 	return nil
 }
+
+func toAWSInstance(instance *ec2.Instance) Instance {
+	result := Instance{
+		ID:        aws.StringValue(instance.InstanceId),
+		Name:      ec2TagValue(instance.Tags, "Name"),
+		Tags:      make(map[string]string, len(instance.Tags)),
+		PrivateIP: aws.StringValue(instance.PrivateIpAddress),
+		PublicIP:  aws.StringValue(instance.PublicIpAddress),
+		CreatedAt: aws.TimeValue(instance.LaunchTime),
+		Image:     aws.StringValue(instance.ImageId),
+	}
+	if instance.Placement != nil {
+		result.Zone = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+	for _, tag := range instance.Tags {
+		result.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	if instance.State != nil {
+		result.State = awsInstanceState(aws.StringValue(instance.State.Name))
+	}
+	return result
+}
+
+// awsInstanceState maps an EC2 instance state name onto the provider-neutral states.
+func awsInstanceState(state string) string {
+	switch state {
+	case ec2.InstanceStateNamePending:
+		return InstancePending
+	case ec2.InstanceStateNameRunning:
+		return InstanceRunning
+	case ec2.InstanceStateNameStopping:
+		return InstanceStopping
+	case ec2.InstanceStateNameStopped:
+		return InstanceStopped
+	case ec2.InstanceStateNameShuttingDown:
+		return InstanceTerminating
+	case ec2.InstanceStateNameTerminated:
+		return InstanceTerminated
+	default:
+		return InstanceUnknown
+	}
+}
+
+func ec2TagMap(tags []*ec2.Tag) map[string]string {
+	result := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		result[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return result
+}
+
+// sshKeyMaterial returns the type and key fields of an OpenSSH public key, dropping the comment.
+func sshKeyMaterial(publicKey string) string {
+	fields := strings.Fields(publicKey)
+	if len(fields) > 2 {
+		fields = fields[:2]
+	}
+	return strings.Join(fields, " ")
+}
+
+func ec2TagValue(tags []*ec2.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+// awsAuthErrorCodes are the error codes AWS returns when it rejects the
+// caller's credentials, or when no credentials could be found.
+var awsAuthErrorCodes = map[string]bool{
+	"AuthFailure":           true,
+	"UnauthorizedOperation": true,
+	"InvalidClientTokenId":  true,
+	"SignatureDoesNotMatch": true,
+	"ExpiredToken":          true,
+	"NoCredentialProviders": true,
+}
+
+// isAWSAuthError reports whether err is AWS rejecting the caller's identity or permissions.
+func isAWSAuthError(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) &&
+		(reqErr.StatusCode() == http.StatusUnauthorized || reqErr.StatusCode() == http.StatusForbidden) {
+		return true
+	}
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && awsAuthErrorCodes[aerr.Code()]
+}
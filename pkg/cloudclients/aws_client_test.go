@@ -0,0 +1,192 @@
+package cloudclients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+)
+
+func TestKeyPairNameDiffersPerIncarnation(t *testing.T) {
+	first := &awsProvider{owner: Owner{Namespace: "default", Name: "web", UID: "1234"}}
+	second := &awsProvider{owner: Owner{Namespace: "default", Name: "web", UID: "5678"}}
+	if first.keyPairName() == second.keyPairName() {
+		t.Errorf("MyResources with different UIDs share key pair %q", first.keyPairName())
+	}
+}
+
+func TestKeyPairNameFitsEC2Limit(t *testing.T) {
+	const uid = "0f8fad5b-d9cb-469f-a165-70867728950e"
+	tests := []struct {
+		name      string
+		namespace string
+		resource  string
+	}{
+		{name: "short", namespace: "default", resource: "web"},
+		{name: "longest name", namespace: strings.Repeat("n", 63), resource: strings.Repeat("r", 253)},
+	}
+	for _, tt := range tests {
+		p := &awsProvider{owner: Owner{Namespace: tt.namespace, Name: tt.resource, UID: uid}}
+		got := p.keyPairName()
+		if len(got) > ec2KeyPairNameMaxLength {
+			t.Errorf("%s: keyPairName() is %d characters, want at most %d", tt.name, len(got), ec2KeyPairNameMaxLength)
+		}
+		if !strings.HasPrefix(got, "myresource-") || !strings.HasSuffix(got, "-"+uid) {
+			t.Errorf("%s: keyPairName() = %q, want the myresource- prefix and the UID suffix", tt.name, got)
+		}
+	}
+}
+
+func TestConfigureLaunch(t *testing.T) {
+	tests := []struct {
+		name   string
+		config devopsv1.AWSConfigSpec
+		index  int
+		check  func(t *testing.T, input *ec2.RunInstancesInput)
+	}{
+		{
+			name:   "defaults",
+			config: devopsv1.AWSConfigSpec{},
+			check: func(t *testing.T, input *ec2.RunInstancesInput) {
+				if input.SubnetId != nil || input.IamInstanceProfile != nil || input.MetadataOptions != nil ||
+					input.Placement != nil || input.BlockDeviceMappings != nil {
+					t.Errorf("unexpected launch settings: %v", input)
+				}
+			},
+		},
+		{
+			name:   "subnets are spread by index",
+			config: devopsv1.AWSConfigSpec{SubnetIDs: []string{"subnet-a", "subnet-b"}, SecurityGroupIDs: []string{"sg-1"}},
+			index:  3,
+			check: func(t *testing.T, input *ec2.RunInstancesInput) {
+				if got := aws.StringValue(input.SubnetId); got != "subnet-b" {
+					t.Errorf("SubnetId = %q, want subnet-b", got)
+				}
+				if got := aws.StringValueSlice(input.SecurityGroupIds); len(got) != 1 || got[0] != "sg-1" {
+					t.Errorf("SecurityGroupIds = %v", got)
+				}
+			},
+		},
+		{
+			name:   "IMDSv2",
+			config: devopsv1.AWSConfigSpec{RequireIMDSv2: aws.Bool(true)},
+			check: func(t *testing.T, input *ec2.RunInstancesInput) {
+				if input.MetadataOptions == nil ||
+					aws.StringValue(input.MetadataOptions.HttpTokens) != ec2.HttpTokensStateRequired {
+					t.Errorf("MetadataOptions = %v, want tokens required", input.MetadataOptions)
+				}
+			},
+		},
+		{
+			name:   "instance profile by ARN",
+			config: devopsv1.AWSConfigSpec{IAMInstanceProfile: "arn:aws:iam::123456789012:instance-profile/web"},
+			check: func(t *testing.T, input *ec2.RunInstancesInput) {
+				if input.IamInstanceProfile == nil || input.IamInstanceProfile.Name != nil ||
+					aws.StringValue(input.IamInstanceProfile.Arn) != "arn:aws:iam::123456789012:instance-profile/web" {
+					t.Errorf("IamInstanceProfile = %v", input.IamInstanceProfile)
+				}
+			},
+		},
+		{
+			name:   "instance profile by name",
+			config: devopsv1.AWSConfigSpec{IAMInstanceProfile: "web"},
+			check: func(t *testing.T, input *ec2.RunInstancesInput) {
+				if input.IamInstanceProfile == nil || aws.StringValue(input.IamInstanceProfile.Name) != "web" {
+					t.Errorf("IamInstanceProfile = %v", input.IamInstanceProfile)
+				}
+			},
+		},
+		{
+			name: "encrypted root volume",
+			config: devopsv1.AWSConfigSpec{
+				RootVolume: &devopsv1.AWSRootVolume{SizeGiB: 40, Type: "gp3", Encrypted: true, KMSKeyID: "alias/ebs"},
+			},
+			check: func(t *testing.T, input *ec2.RunInstancesInput) {
+				ebs := rootVolume(t, input)
+				if aws.Int64Value(ebs.VolumeSize) != 40 || aws.StringValue(ebs.VolumeType) != "gp3" ||
+					!aws.BoolValue(ebs.Encrypted) || aws.StringValue(ebs.KmsKeyId) != "alias/ebs" ||
+					!aws.BoolValue(ebs.DeleteOnTermination) {
+					t.Errorf("Ebs = %v", ebs)
+				}
+			},
+		},
+		{
+			name: "KMS key implies encryption",
+			config: devopsv1.AWSConfigSpec{
+				RootVolume: &devopsv1.AWSRootVolume{KMSKeyID: "alias/ebs"},
+			},
+			check: func(t *testing.T, input *ec2.RunInstancesInput) {
+				ebs := rootVolume(t, input)
+				if !aws.BoolValue(ebs.Encrypted) || aws.StringValue(ebs.KmsKeyId) != "alias/ebs" {
+					t.Errorf("Ebs = %v, want encrypted with alias/ebs", ebs)
+				}
+				if ebs.VolumeSize != nil || ebs.VolumeType != nil {
+					t.Errorf("Ebs = %v, want the AMI's size and type", ebs)
+				}
+			},
+		},
+		{
+			name: "placement",
+			config: devopsv1.AWSConfigSpec{
+				Placement: &devopsv1.AWSPlacement{AvailabilityZone: "us-east-1a", Tenancy: "dedicated"},
+			},
+			check: func(t *testing.T, input *ec2.RunInstancesInput) {
+				if input.Placement == nil || aws.StringValue(input.Placement.AvailabilityZone) != "us-east-1a" ||
+					aws.StringValue(input.Placement.Tenancy) != "dedicated" || input.Placement.GroupName != nil {
+					t.Errorf("Placement = %v", input.Placement)
+				}
+			},
+		},
+	}
+	ec2Svc := newTestEC2(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &awsProvider{ec2Svc: ec2Svc, config: tt.config}
+			input := &ec2.RunInstancesInput{ImageId: aws.String("ami-0abcdef1234567890")}
+			if err := p.configureLaunch(context.Background(), input, tt.index); err != nil {
+				t.Fatalf("configureLaunch() error = %v", err)
+			}
+			tt.check(t, input)
+		})
+	}
+}
+
+// newTestEC2 returns an EC2 client whose DescribeImages calls report the
+// root device of a single AMI, which root volume overrides look up.
+func newTestEC2(t *testing.T) *ec2.EC2 {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = w.Write([]byte(`<DescribeImagesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>1</requestId>
+  <imagesSet><item><imageId>ami-0abcdef1234567890</imageId><rootDeviceName>/dev/xvda</rootDeviceName></item></imagesSet>
+</DescribeImagesResponse>`))
+	}))
+	t.Cleanup(server.Close)
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	return ec2.New(sess)
+}
+
+// rootVolume returns the EBS settings of the single root device mapping in input.
+func rootVolume(t *testing.T, input *ec2.RunInstancesInput) *ec2.EbsBlockDevice {
+	t.Helper()
+	if len(input.BlockDeviceMappings) != 1 {
+		t.Fatalf("BlockDeviceMappings = %v, want one mapping", input.BlockDeviceMappings)
+	}
+	mapping := input.BlockDeviceMappings[0]
+	if got := aws.StringValue(mapping.DeviceName); got != "/dev/xvda" {
+		t.Errorf("DeviceName = %q, want /dev/xvda", got)
+	}
+	return mapping.Ebs
+}
@@ -2,148 +2,859 @@ package cloudclients
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
-	"time"
+	"net/http"
+	"path"
+	"strings"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
 
 	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
 )
 
-// UpdateAzureInstances ensures the running Azure VMs match 'desiredCount'.
-func UpdateAzureInstances(
-	ctx context.Context,
-	config devopsv1.AzureConfigSpec,
-	currentCount int,
-	desiredCount int,
-) error {
-	// 1. Initialize Azure clients.
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+// azureProvider manages Azure VMs for a single MyResource.
+type azureProvider struct {
+	vmClient   *armcompute.VirtualMachinesClient
+	diskClient *armcompute.DisksClient
+	nicClient  *armnetwork.InterfacesClient
+	pipClient  *armnetwork.PublicIPAddressesClient
+	config     devopsv1.AzureConfigSpec
+	owner      Owner
+}
+
+// NewAzureProvider builds a Provider backed by Azure Compute. It authenticates with
+// the service principal in creds, or the default Azure credential if creds is nil.
+func NewAzureProvider(ctx context.Context, res *devopsv1.MyResource, creds Credentials) (Provider, error) {
+	if res.Spec.AzureConfig == nil {
+		return nil, fmt.Errorf("azureConfig is not set")
+	}
+	config := *res.Spec.AzureConfig
+
+	cred, err := azureCredential(creds)
 	if err != nil {
-		return fmt.Errorf("failed to obtain Azure credential: %w", err)
+		return nil, err
 	}
 
 	vmClient, err := armcompute.NewVirtualMachinesClient(config.SubscriptionID, cred, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create VM client: %w", err)
+		return nil, fmt.Errorf("failed to create VM client: %w", err)
 	}
 
-	diff := desiredCount - currentCount
-	if diff > 0 {
-		for i := 0; i < diff; i++ {
-			if err := createAzureVM(ctx, vmClient, config); err != nil {
-				return err
-			}
+	diskClient, err := armcompute.NewDisksClient(config.SubscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create disk client: %w", err)
+	}
+
+	nicClient, err := armnetwork.NewInterfacesClient(config.SubscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network interface client: %w", err)
+	}
+
+	pipClient, err := armnetwork.NewPublicIPAddressesClient(config.SubscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public IP client: %w", err)
+	}
+
+	return &azureProvider{
+		vmClient:   vmClient,
+		diskClient: diskClient,
+		nicClient:  nicClient,
+		pipClient:  pipClient,
+		config:     config,
+		owner:      OwnerOf(res),
+	}, nil
+}
+
+func (p *azureProvider) Name() string {
+	return ProviderAzure
+}
+
+// ListInstances returns the VMs in the configured resource group tagged as owned by the MyResource.
+func (p *azureProvider) ListInstances(ctx context.Context) ([]Instance, error) {
+	instances, err := p.listAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var owned []Instance
+	for _, instance := range instances {
+		if ownedBy(instance.Tags, p.owner.Tags()) {
+			owned = append(owned, instance)
 		}
-	} else if diff < 0 {
-		toDelete := -diff
-		if err := deleteAzureVMs(ctx, vmClient, config, toDelete); err != nil {
-			return err
+	}
+	return owned, p.fillStates(ctx, owned)
+}
+
+// ListOrphans returns the VMs released by an earlier MyResource with the same namespace and name.
+func (p *azureProvider) ListOrphans(ctx context.Context) ([]Instance, error) {
+	instances, err := p.listAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []Instance
+	for _, instance := range instances {
+		if orphanedBy(instance.Tags, p.owner.identityTags()) {
+			orphans = append(orphans, instance)
 		}
 	}
+	return orphans, p.fillStates(ctx, orphans)
+}
+
+// listAll returns every VM in the configured resource group, without their
+// states. The VM list API cannot filter by tag, so callers filter client side
+// and then fill in the states of the VMs they keep.
+func (p *azureProvider) listAll(ctx context.Context) ([]Instance, error) {
+	addresses, err := p.listAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
 
+	pager := p.vmClient.NewListPager(p.config.ResourceGroup, nil)
+	var instances []Instance
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VMs: %w", err)
+		}
+		for _, vm := range page.Value {
+			instances = append(instances, toAzureInstance(vm, addresses))
+		}
+	}
+
+	return instances, nil
+}
+
+// fillStates sets the state of each instance from its instance view, which
+// the VM list API does not return.
+func (p *azureProvider) fillStates(ctx context.Context, instances []Instance) error {
+	for i := range instances {
+		view, err := p.vmClient.InstanceView(ctx, p.config.ResourceGroup, instances[i].Name, nil)
+		switch {
+		case isAzureNotFound(err):
+			// Deleted since it was listed.
+			instances[i].State = InstanceTerminated
+		case err != nil:
+			return fmt.Errorf("failed to get instance view of VM %s: %w", instances[i].Name, err)
+		default:
+			instances[i].State = azureInstanceState(view.Statuses)
+		}
+	}
 	return nil
 }
 
-// createAzureVM creates a single Azure VM with the specified config.
-func createAzureVM(
-	ctx context.Context,
-	vmClient *armcompute.VirtualMachinesClient,
-	config devopsv1.AzureConfigSpec,
-) error {
-	rand.Seed(time.Now().UnixNano())
-	vmName := fmt.Sprintf("myresource-%d", rand.Intn(1000000))
+// azureAddresses are the IP addresses bound to a network interface.
+type azureAddresses struct {
+	privateIP string
+	publicIP  string
+}
+
+// azureCredential returns a service principal credential built from creds, or
+// the default Azure credential chain if creds is nil.
+func azureCredential(creds Credentials) (azcore.TokenCredential, error) {
+	if creds == nil {
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain Azure credential: %w", err)
+		}
+		return cred, nil
+	}
+
+	tenantID, err := creds.required(AzureTenantIDKey)
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := creds.required(AzureClientIDKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret := creds[AzureClientSecretKey]; len(secret) > 0 {
+		cred, err := azidentity.NewClientSecretCredential(string(tenantID), string(clientID), string(secret), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build Azure client secret credential: %w: %w", ErrInvalidCredentials, err)
+		}
+		return cred, nil
+	}
+
+	certData, err := creds.required(AzureClientCertificateKey)
+	if err != nil {
+		return nil, fmt.Errorf("credentials secret has neither %q nor %q: %w",
+			AzureClientSecretKey, AzureClientCertificateKey, ErrInvalidCredentials)
+	}
+	certs, key, err := azidentity.ParseCertificates(certData, creds[AzureClientCertificatePasswordKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Azure client certificate: %w: %w", ErrInvalidCredentials, err)
+	}
+	cred, err := azidentity.NewClientCertificateCredential(string(tenantID), string(clientID), certs, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Azure client certificate credential: %w: %w", ErrInvalidCredentials, err)
+	}
+	return cred, nil
+}
+
+// listAddresses maps the (lowercased) IDs of the network interfaces in the
+// resource group to their IP addresses. VMs only reference their NICs, and NICs
+// only reference their public IPs, so both are listed once up front.
+func (p *azureProvider) listAddresses(ctx context.Context) (map[string]azureAddresses, error) {
+	publicIPs := make(map[string]string)
+	pipPager := p.pipClient.NewListPager(p.config.ResourceGroup, nil)
+	for pipPager.More() {
+		page, err := pipPager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list public IP addresses: %w", err)
+		}
+		for _, pip := range page.Value {
+			if pip.ID != nil && pip.Properties != nil && pip.Properties.IPAddress != nil {
+				publicIPs[strings.ToLower(*pip.ID)] = *pip.Properties.IPAddress
+			}
+		}
+	}
 
-	log.Printf("[Azure] Creating VM: %s in resource group: %s", vmName, config.ResourceGroup)
+	addresses := make(map[string]azureAddresses)
+	nicPager := p.nicClient.NewListPager(p.config.ResourceGroup, nil)
+	for nicPager.More() {
+		page, err := nicPager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+		}
+		for _, nic := range page.Value {
+			if nic.ID == nil || nic.Properties == nil {
+				continue
+			}
+			var addr azureAddresses
+			for _, ipConfig := range nic.Properties.IPConfigurations {
+				if ipConfig.Properties == nil {
+					continue
+				}
+				if addr.privateIP == "" && ipConfig.Properties.PrivateIPAddress != nil {
+					addr.privateIP = *ipConfig.Properties.PrivateIPAddress
+				}
+				if addr.publicIP == "" && ipConfig.Properties.PublicIPAddress != nil && ipConfig.Properties.PublicIPAddress.ID != nil {
+					addr.publicIP = publicIPs[strings.ToLower(*ipConfig.Properties.PublicIPAddress.ID)]
+				}
+			}
+			addresses[strings.ToLower(*nic.ID)] = addr
+		}
+	}
 
-	vmParams := armcompute.VirtualMachine{
-		Location: &config.Region,
+	return addresses, nil
+}
+
+// CreateInstance creates a single Azure VM with the specified config.
+func (p *azureProvider) CreateInstance(ctx context.Context, req InstanceRequest) (*Instance, error) {
+	vmName := req.Name
+
+	// CreateOrUpdate would silently update a VM that already exists under
+	// this name, so look first and return it if a previous attempt made it.
+	existing, err := p.vmClient.Get(ctx, p.config.ResourceGroup, vmName, nil)
+	var respErr *azcore.ResponseError
+	switch {
+	case err == nil && !ownedBy(fromAzureTags(existing.Tags), p.owner.Tags()):
+		return nil, fmt.Errorf("VM %s already exists: %w", vmName, ErrNotOwned)
+	case err == nil:
+		log.Printf("[Azure] VM %s already exists; adopting it", vmName)
+		return p.DescribeInstance(ctx, *existing.ID)
+	case !errors.As(err, &respErr) || respErr.StatusCode != http.StatusNotFound:
+		return nil, fmt.Errorf("failed to check for existing VM %s: %w", vmName, err)
+	}
+
+	log.Printf("[Azure] Creating VM: %s in resource group: %s", vmName, p.config.ResourceGroup)
+
+	tags := p.NormalizeTags(req.Tags)
+	for key, value := range p.owner.Tags() {
+		tags[key] = value
+	}
+
+	nicID := p.config.NetworkInterfaceID
+	if p.config.SubnetID != "" {
+		var err error
+		if nicID, err = p.createNetworkInterface(ctx, vmName, tags); err != nil {
+			return nil, err
+		}
+	}
+
+	pollerResp, err := p.vmClient.BeginCreateOrUpdate(ctx, p.config.ResourceGroup, vmName, p.vmParameters(req, nicID, tags), nil)
+	if err == nil {
+		var resp armcompute.VirtualMachinesClientCreateOrUpdateResponse
+		if resp, err = pollerResp.PollUntilDone(ctx, nil); err == nil {
+			log.Printf("[Azure] VM %s creation completed successfully.", vmName)
+			p.tagOSDisk(ctx, &resp.VirtualMachine, tags)
+			return p.DescribeInstance(ctx, *resp.ID)
+		}
+	}
+
+	// Without a VM nothing references the NIC created above, so remove it.
+	if p.config.SubnetID != "" {
+		if cleanupErr := p.deleteNetworkInterface(ctx, nicID); cleanupErr != nil {
+			log.Printf("[Azure] Failed to clean up NIC of VM %s: %v", vmName, cleanupErr)
+		}
+	}
+	return nil, fmt.Errorf("failed to create VM %s: %w", vmName, err)
+}
+
+// vmParameters returns the VM to create for req, attached to the NIC with the
+// given ID. Azure deletes the OS disk along with the VM, and the NIC too if it
+// was created for the VM rather than supplied through NetworkInterfaceID.
+func (p *azureProvider) vmParameters(req InstanceRequest, nicID string, tags map[string]string) armcompute.VirtualMachine {
+	osProfile := &armcompute.OSProfile{
+		ComputerName:  &req.Name,
+		AdminUsername: &p.config.AdminUsername,
+	}
+	if req.AdminPassword != "" {
+		osProfile.AdminPassword = &req.AdminPassword
+	}
+	if req.UserData != "" {
+		customData := base64.StdEncoding.EncodeToString([]byte(req.UserData))
+		osProfile.CustomData = &customData
+	}
+	if len(req.SSHPublicKeys) > 0 {
+		osProfile.LinuxConfiguration = p.linuxConfiguration(req)
+	}
+
+	nic := &armcompute.NetworkInterfaceReference{ID: &nicID}
+	if p.config.SubnetID != "" {
+		nic.Properties = &armcompute.NetworkInterfaceReferenceProperties{
+			DeleteOption: to.Ptr(armcompute.DeleteOptionsDelete),
+		}
+	}
+
+	return armcompute.VirtualMachine{
+		Location: &p.config.Region,
+		Tags:     azureTags(tags),
 		Properties: &armcompute.VirtualMachineProperties{
 			HardwareProfile: &armcompute.HardwareProfile{
-				VMSize: armcompute.VirtualMachineSizeTypes(config.VMSize),
+				VMSize: (*armcompute.VirtualMachineSizeTypes)(&p.config.VMSize),
 			},
 			StorageProfile: &armcompute.StorageProfile{
 				ImageReference: &armcompute.ImageReference{
-					Publisher: &config.ImagePublisher,
-					Offer:     &config.ImageOffer,
-					SKU:       &config.ImageSKU,
-					Version:   &config.ImageVersion,
+					Publisher: &p.config.ImagePublisher,
+					Offer:     &p.config.ImageOffer,
+					SKU:       &p.config.ImageSKU,
+					Version:   &p.config.ImageVersion,
+				},
+				OSDisk: &armcompute.OSDisk{
+					CreateOption: to.Ptr(armcompute.DiskCreateOptionTypesFromImage),
+					DeleteOption: to.Ptr(armcompute.DiskDeleteOptionTypesDelete),
 				},
 			},
-			OSProfile: &armcompute.OSProfile{
-				ComputerName:  &vmName,
-				AdminUsername: &config.AdminUsername,
-				AdminPassword: &config.AdminPassword,
-			},
+			OSProfile: osProfile,
 			NetworkProfile: &armcompute.NetworkProfile{
-				NetworkInterfaces: []*armcompute.NetworkInterfaceReference{
-					{
-						ID: &config.NetworkInterfaceID,
-					},
-				},
+				NetworkInterfaces: []*armcompute.NetworkInterfaceReference{nic},
 			},
 		},
 	}
+}
 
-	pollerResp, err := vmClient.BeginCreateOrUpdate(ctx, config.ResourceGroup, vmName, vmParams, nil)
+// tagOSDisk copies the VM's tags onto its OS disk, which Azure creates
+// untagged, so Cleanup can find the disk should deleting it with the VM fail.
+// Failures are only logged: the disk is still deleted along with the VM.
+func (p *azureProvider) tagOSDisk(ctx context.Context, vm *armcompute.VirtualMachine, tags map[string]string) {
+	diskName := azureOSDiskName(vm)
+	if diskName == "" {
+		return
+	}
+	poller, err := p.diskClient.BeginUpdate(ctx, p.config.ResourceGroup, diskName,
+		armcompute.DiskUpdate{Tags: azureTags(tags)}, nil)
+	if err == nil {
+		_, err = poller.PollUntilDone(ctx, nil)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to start VM creation: %w", err)
+		log.Printf("[Azure] Failed to tag OS disk %s: %v", diskName, err)
 	}
+}
 
-	_, err = pollerResp.PollUntilDone(ctx, nil)
+// azureOSDiskName returns the name of the VM's OS disk, or "" if unknown.
+func azureOSDiskName(vm *armcompute.VirtualMachine) string {
+	if props := vm.Properties; props != nil && props.StorageProfile != nil &&
+		props.StorageProfile.OSDisk != nil && props.StorageProfile.OSDisk.Name != nil {
+		return *props.StorageProfile.OSDisk.Name
+	}
+	return ""
+}
+
+// createNetworkInterface creates the NIC, and public IP if configured, for
+// the VM with the given name and returns the NIC's ID. Both carry the VM's tags.
+func (p *azureProvider) createNetworkInterface(ctx context.Context, vmName string, tags map[string]string) (string, error) {
+	ipConfig := &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+		Subnet:                    &armnetwork.Subnet{ID: &p.config.SubnetID},
+		PrivateIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodDynamic),
+	}
+
+	if p.config.PublicIP != nil && *p.config.PublicIP {
+		pipPoller, err := p.pipClient.BeginCreateOrUpdate(ctx, p.config.ResourceGroup, vmName+"-pip",
+			armnetwork.PublicIPAddress{
+				Location: &p.config.Region,
+				Tags:     azureTags(tags),
+				SKU:      &armnetwork.PublicIPAddressSKU{Name: to.Ptr(armnetwork.PublicIPAddressSKUNameStandard)},
+				Properties: &armnetwork.PublicIPAddressPropertiesFormat{
+					PublicIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodStatic),
+				},
+			}, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to start public IP creation for VM %s: %w", vmName, err)
+		}
+		pip, err := pipPoller.PollUntilDone(ctx, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create public IP for VM %s: %w", vmName, err)
+		}
+		ipConfig.PublicIPAddress = &armnetwork.PublicIPAddress{ID: pip.ID}
+	}
+
+	nicPoller, err := p.nicClient.BeginCreateOrUpdate(ctx, p.config.ResourceGroup, vmName+"-nic",
+		armnetwork.Interface{
+			Location: &p.config.Region,
+			Tags:     azureTags(tags),
+			Properties: &armnetwork.InterfacePropertiesFormat{
+				IPConfigurations: []*armnetwork.InterfaceIPConfiguration{
+					{Name: to.Ptr("ipconfig1"), Properties: ipConfig},
+				},
+			},
+		}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to start NIC creation for VM %s: %w", vmName, err)
+	}
+	nic, err := nicPoller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create NIC for VM %s: %w", vmName, err)
+	}
+	log.Printf("[Azure] Created NIC %s", *nic.Name)
+	return *nic.ID, nil
+}
+
+// ownsSupportingResource reports whether a NIC, public IP or disk with the
+// given tags was created for one of the MyResource's VMs. Only the namespace
+// and name are compared: adopting orphaned VMs re-tags the VMs alone.
+func (p *azureProvider) ownsSupportingResource(tags map[string]*string) bool {
+	return ownedBy(fromAzureTags(tags), p.owner.identityTags())
+}
+
+// deleteNetworkInterface deletes the NIC with the given ID and the public IPs
+// attached to it, if they carry the MyResource's ownership tags. A NIC that
+// no longer exists, e.g. because Azure deleted it with its VM, is skipped.
+func (p *azureProvider) deleteNetworkInterface(ctx context.Context, nicID string) error {
+	nic, err := p.nicClient.Get(ctx, p.config.ResourceGroup, path.Base(nicID), nil)
+	if isAzureNotFound(err) {
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create VM: %w", err)
+		return fmt.Errorf("failed to get NIC %s: %w", nicID, err)
+	}
+	if !p.ownsSupportingResource(nic.Tags) {
+		// Not created by this provider, e.g. the deprecated NetworkInterfaceID.
+		return nil
+	}
+
+	poller, err := p.nicClient.BeginDelete(ctx, p.config.ResourceGroup, *nic.Name, nil)
+	if err == nil {
+		_, err = poller.PollUntilDone(ctx, nil)
+	}
+	if err != nil && !isAzureNotFound(err) {
+		return fmt.Errorf("failed to delete NIC %s: %w", *nic.Name, err)
+	}
+	log.Printf("[Azure] Deleted NIC %s", *nic.Name)
+
+	var errs []error
+	for _, pipID := range azurePublicIPIDs(&nic.Interface) {
+		errs = append(errs, p.deletePublicIP(ctx, pipID))
+	}
+	return errors.Join(errs...)
+}
+
+// deletePublicIP deletes the public IP with the given ID if it carries the
+// MyResource's ownership tags and still exists.
+func (p *azureProvider) deletePublicIP(ctx context.Context, pipID string) error {
+	pip, err := p.pipClient.Get(ctx, p.config.ResourceGroup, path.Base(pipID), nil)
+	if isAzureNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get public IP %s: %w", pipID, err)
+	}
+	if !p.ownsSupportingResource(pip.Tags) {
+		return nil
 	}
 
-	log.Printf("[Azure] VM %s creation completed successfully.", vmName)
+	poller, err := p.pipClient.BeginDelete(ctx, p.config.ResourceGroup, *pip.Name, nil)
+	if err == nil {
+		_, err = poller.PollUntilDone(ctx, nil)
+	}
+	if err != nil && !isAzureNotFound(err) {
+		return fmt.Errorf("failed to delete public IP %s: %w", *pip.Name, err)
+	}
+	log.Printf("[Azure] Deleted public IP %s", *pip.Name)
 	return nil
 }
 
-// Removes 'numToDelete' VMs managed by the operator.
-func deleteAzureVMs(
-	ctx context.Context,
-	vmClient *armcompute.VirtualMachinesClient,
-	config devopsv1.AzureConfigSpec,
-	numToDelete int,
-) error {
-	// List VMs in the resource group.
-	pager := vmClient.NewListPager(config.ResourceGroup, nil)
-	var candidateVMs []string
+// deleteOSDisk deletes the managed disk with the given name if it carries the
+// MyResource's ownership tags and still exists.
+func (p *azureProvider) deleteOSDisk(ctx context.Context, diskName string) error {
+	disk, err := p.diskClient.Get(ctx, p.config.ResourceGroup, diskName, nil)
+	if isAzureNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get OS disk %s: %w", diskName, err)
+	}
+	if !p.ownsSupportingResource(disk.Tags) {
+		return nil
+	}
 
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to list VMs: %w", err)
+	poller, err := p.diskClient.BeginDelete(ctx, p.config.ResourceGroup, diskName, nil)
+	if err == nil {
+		_, err = poller.PollUntilDone(ctx, nil)
+	}
+	if err != nil && !isAzureNotFound(err) {
+		return fmt.Errorf("failed to delete OS disk %s: %w", diskName, err)
+	}
+	log.Printf("[Azure] Deleted OS disk %s", diskName)
+	return nil
+}
+
+// azurePublicIPIDs returns the IDs of the public IPs attached to the NIC.
+func azurePublicIPIDs(nic *armnetwork.Interface) []string {
+	var ids []string
+	if nic.Properties == nil {
+		return nil
+	}
+	for _, ipConfig := range nic.Properties.IPConfigurations {
+		if ipConfig.Properties != nil && ipConfig.Properties.PublicIPAddress != nil &&
+			ipConfig.Properties.PublicIPAddress.ID != nil {
+			ids = append(ids, *ipConfig.Properties.PublicIPAddress.ID)
 		}
-		for _, vm := range page.Value {
-			if vm.Name != nil && len(*vm.Name) >= 11 && (*vm.Name)[:11] == "myresource-" {
-				candidateVMs = append(candidateVMs, *vm.Name)
+	}
+	return ids
+}
+
+// isAzureNotFound reports whether err is an Azure 404 response.
+func isAzureNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// isAzureAuthError reports whether err is Azure rejecting the caller's identity
+// or permissions, or Microsoft Entra ID refusing to issue it a token.
+func isAzureAuthError(err error) bool {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode == http.StatusUnauthorized || respErr.StatusCode == http.StatusForbidden
+	}
+	var authErr *azidentity.AuthenticationFailedError
+	return errors.As(err, &authErr)
+}
+
+// linuxConfiguration authorizes the SSH keys of req for the admin user. Password
+// login stays enabled only if a password was supplied.
+func (p *azureProvider) linuxConfiguration(req InstanceRequest) *armcompute.LinuxConfiguration {
+	authorizedKeys := fmt.Sprintf("/home/%s/.ssh/authorized_keys", p.config.AdminUsername)
+	publicKeys := make([]*armcompute.SSHPublicKey, 0, len(req.SSHPublicKeys))
+	for _, key := range req.SSHPublicKeys {
+		publicKeys = append(publicKeys, &armcompute.SSHPublicKey{
+			Path:    &authorizedKeys,
+			KeyData: &key,
+		})
+	}
+	disablePassword := req.AdminPassword == ""
+	return &armcompute.LinuxConfiguration{
+		DisablePasswordAuthentication: &disablePassword,
+		SSH:                           &armcompute.SSHConfiguration{PublicKeys: publicKeys},
+	}
+}
+
+// DeleteInstance deletes the VM identified by its Azure resource ID. Azure
+// deletes the NIC and OS disk of VMs created with a delete option along with
+// them; those of older VMs, and public IPs, are deleted here. Anything left
+// behind once the VM is gone is swept up by Cleanup.
+func (p *azureProvider) DeleteInstance(ctx context.Context, id string) error {
+	vmName := path.Base(id)
+	vm, err := p.vmClient.Get(ctx, p.config.ResourceGroup, vmName, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get VM %s: %w", id, err)
+	}
+	if !ownedBy(fromAzureTags(vm.Tags), p.owner.Tags()) {
+		return fmt.Errorf("refusing to delete Azure VM %s: %w", id, ErrNotOwned)
+	}
+
+	// Look up the public IPs first: the NICs referencing them may be
+	// deleted along with the VM.
+	var nicIDs, publicIPs []string
+	if props := vm.Properties; props != nil && props.NetworkProfile != nil {
+		for _, ref := range props.NetworkProfile.NetworkInterfaces {
+			if ref.ID == nil {
+				continue
+			}
+			nicIDs = append(nicIDs, *ref.ID)
+			nic, err := p.nicClient.Get(ctx, p.config.ResourceGroup, path.Base(*ref.ID), nil)
+			if err != nil && !isAzureNotFound(err) {
+				return fmt.Errorf("failed to get NIC %s: %w", *ref.ID, err)
+			}
+			if err == nil {
+				publicIPs = append(publicIPs, azurePublicIPIDs(&nic.Interface)...)
 			}
 		}
 	}
+	osDisk := azureOSDiskName(&vm.VirtualMachine)
+
+	pollerResp, err := p.vmClient.BeginDelete(ctx, p.config.ResourceGroup, vmName, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start VM deletion for %s: %w", vmName, err)
+	}
+	_, err = pollerResp.PollUntilDone(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete VM %s: %w", vmName, err)
+	}
+	log.Printf("[Azure] VM %s deletion completed successfully.", vmName)
 
-	if len(candidateVMs) < numToDelete {
-		numToDelete = len(candidateVMs)
+	// Keep going after a failure so as little as possible is left to Cleanup.
+	var errs []error
+	for _, nicID := range nicIDs {
+		errs = append(errs, p.deleteNetworkInterface(ctx, nicID))
 	}
+	for _, pipID := range publicIPs {
+		errs = append(errs, p.deletePublicIP(ctx, pipID))
+	}
+	if osDisk != "" {
+		errs = append(errs, p.deleteOSDisk(ctx, osDisk))
+	}
+	return errors.Join(errs...)
+}
+
+// Cleanup deletes the NICs, public IPs and disks in the resource group that
+// carry the MyResource's ownership tags and are no longer in use, such as
+// those left behind when DeleteInstance failed after deleting their VM.
+func (p *azureProvider) Cleanup(ctx context.Context) error {
+	var errs []error
 
-	toDeleteList := candidateVMs[:numToDelete]
-	log.Printf("[Azure] Deleting %d VM(s): %v", numToDelete, toDeleteList)
+	nicPager := p.nicClient.NewListPager(p.config.ResourceGroup, nil)
+	for nicPager.More() {
+		page, err := nicPager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list network interfaces: %w", err)
+		}
+		for _, nic := range page.Value {
+			if nic.ID == nil || !p.ownsSupportingResource(nic.Tags) ||
+				(nic.Properties != nil && nic.Properties.VirtualMachine != nil) {
+				continue
+			}
+			errs = append(errs, p.deleteNetworkInterface(ctx, *nic.ID))
+		}
+	}
 
-	for _, vmName := range toDeleteList {
-		pollerResp, err := vmClient.BeginDelete(ctx, config.ResourceGroup, vmName, nil)
+	pipPager := p.pipClient.NewListPager(p.config.ResourceGroup, nil)
+	for pipPager.More() {
+		page, err := pipPager.NextPage(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to start VM deletion for %s: %w", vmName, err)
+			return fmt.Errorf("failed to list public IP addresses: %w", err)
+		}
+		for _, pip := range page.Value {
+			if pip.ID == nil || !p.ownsSupportingResource(pip.Tags) ||
+				(pip.Properties != nil && pip.Properties.IPConfiguration != nil) {
+				continue
+			}
+			errs = append(errs, p.deletePublicIP(ctx, *pip.ID))
 		}
-		_, err = pollerResp.PollUntilDone(ctx, nil)
+	}
+
+	diskPager := p.diskClient.NewListByResourceGroupPager(p.config.ResourceGroup, nil)
+	for diskPager.More() {
+		page, err := diskPager.NextPage(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to delete VM %s: %w", vmName, err)
+			return fmt.Errorf("failed to list disks: %w", err)
+		}
+		for _, disk := range page.Value {
+			if disk.Name == nil || disk.ManagedBy != nil || !p.ownsSupportingResource(disk.Tags) {
+				continue
+			}
+			errs = append(errs, p.deleteOSDisk(ctx, *disk.Name))
 		}
-		log.Printf("[Azure] VM %s deletion completed successfully.", vmName)
 	}
 
+	return errors.Join(errs...)
+}
+
+// DescribeInstance returns the VM identified by its Azure resource ID.
+func (p *azureProvider) DescribeInstance(ctx context.Context, id string) (*Instance, error) {
+	resp, err := p.vmClient.Get(ctx, p.config.ResourceGroup, path.Base(id),
+		&armcompute.VirtualMachinesClientGetOptions{Expand: to.Ptr(armcompute.InstanceViewTypesInstanceView)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VM %s: %w", id, err)
+	}
+	addresses, err := p.listAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	instance := toAzureInstance(&resp.VirtualMachine, addresses)
+	return &instance, nil
+}
+
+// UpdateTags sets and removes tags on the VM. Azure replaces the whole tag
+// set on update, so the current tags are read first.
+func (p *azureProvider) UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error {
+	instance, err := p.DescribeInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	tags := make(map[string]string, len(instance.Tags)+len(set))
+	for key, value := range instance.Tags {
+		tags[key] = value
+	}
+	for key, value := range set {
+		tags[key] = value
+	}
+	for _, key := range remove {
+		delete(tags, key)
+	}
+
+	pollerResp, err := p.vmClient.BeginUpdate(ctx, p.config.ResourceGroup, instance.Name,
+		armcompute.VirtualMachineUpdate{Tags: azureTags(tags)}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start tag update for VM %s: %w", instance.Name, err)
+	}
+	if _, err := pollerResp.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to update tags on VM %s: %w", instance.Name, err)
+	}
+
+	log.Printf("[Azure] Updated tags on VM %s", instance.Name)
 	return nil
 }
+
+// NormalizeName rewrites name into a valid Linux VM and computer name.
+func (p *azureProvider) NormalizeName(name string) string {
+	return dnsLabel(name, azureNameMaxLength)
+}
+
+// NormalizeTags rewrites tags to fit Azure's tag name and length limits.
+func (p *azureProvider) NormalizeTags(tags map[string]string) map[string]string {
+	return azureTagsFor(tags)
+}
+
+func azureTags(tags map[string]string) map[string]*string {
+	result := make(map[string]*string, len(tags))
+	for key, value := range tags {
+		result[key] = &value
+	}
+	return result
+}
+
+func fromAzureTags(tags map[string]*string) map[string]string {
+	result := make(map[string]string, len(tags))
+	for key, value := range tags {
+		if value != nil {
+			result[key] = *value
+		}
+	}
+	return result
+}
+
+// toAzureInstance converts a VM; addresses comes from listAddresses. The
+// state is only known if the VM was read with its instance view.
+func toAzureInstance(vm *armcompute.VirtualMachine, addresses map[string]azureAddresses) Instance {
+	instance := Instance{Tags: fromAzureTags(vm.Tags), State: InstanceUnknown}
+	if vm.ID != nil {
+		instance.ID = *vm.ID
+	}
+	if vm.Name != nil {
+		instance.Name = *vm.Name
+	}
+	if len(vm.Zones) > 0 && vm.Zones[0] != nil {
+		instance.Zone = *vm.Zones[0]
+	} else if vm.Location != nil {
+		instance.Zone = *vm.Location
+	}
+
+	props := vm.Properties
+	if props == nil {
+		return instance
+	}
+	if props.InstanceView != nil {
+		instance.State = azureInstanceState(props.InstanceView.Statuses)
+	}
+	if props.TimeCreated != nil {
+		instance.CreatedAt = *props.TimeCreated
+	}
+	if props.StorageProfile != nil && props.StorageProfile.ImageReference != nil {
+		instance.Image = azureImageURN(props.StorageProfile.ImageReference)
+	}
+	if props.NetworkProfile != nil {
+		for _, nic := range props.NetworkProfile.NetworkInterfaces {
+			if nic.ID == nil {
+				continue
+			}
+			addr := addresses[strings.ToLower(*nic.ID)]
+			instance.PrivateIP = addr.privateIP
+			instance.PublicIP = addr.publicIP
+			break
+		}
+	}
+	return instance
+}
+
+// azureImageURN formats an image reference as publisher:offer:sku:version,
+// preferring the exact version Azure resolved "latest" to.
+func azureImageURN(ref *armcompute.ImageReference) string {
+	if ref.ID != nil {
+		return *ref.ID
+	}
+	version := ref.Version
+	if ref.ExactVersion != nil {
+		version = ref.ExactVersion
+	}
+	var parts []string
+	for _, part := range []*string{ref.Publisher, ref.Offer, ref.SKU, version} {
+		if part == nil {
+			parts = append(parts, "")
+		} else {
+			parts = append(parts, *part)
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// azureInstanceState maps the status codes of a VM's instance view, e.g.
+// "ProvisioningState/succeeded" and "PowerState/running", onto the
+// provider-neutral states. A stopped or deallocated VM still reports a
+// succeeded provisioning state, so only the power state can make it Running.
+func azureInstanceState(statuses []*armcompute.InstanceViewStatus) string {
+	var provisioning, power string
+	for _, status := range statuses {
+		if status == nil || status.Code == nil {
+			continue
+		}
+		code := strings.ToLower(*status.Code)
+		if state, ok := strings.CutPrefix(code, "provisioningstate/"); ok {
+			provisioning = state
+		} else if state, ok := strings.CutPrefix(code, "powerstate/"); ok {
+			power = state
+		}
+	}
+
+	switch {
+	case provisioning == "creating" || provisioning == "updating" || provisioning == "migrating":
+		return InstancePending
+	case provisioning == "deleting":
+		return InstanceTerminating
+	case strings.HasPrefix(provisioning, "failed"):
+		// e.g. "ProvisioningState/failed/AllocationFailed".
+		return InstanceFailed
+	}
+
+	switch power {
+	case "starting":
+		return InstancePending
+	case "running":
+		return InstanceRunning
+	case "stopping", "deallocating":
+		return InstanceStopping
+	case "stopped", "deallocated":
+		return InstanceStopped
+	default:
+		return InstanceUnknown
+	}
+}
@@ -0,0 +1,109 @@
+package cloudclients
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+)
+
+func TestAzureInstanceState(t *testing.T) {
+	tests := []struct {
+		codes []string
+		want  string
+	}{
+		{codes: []string{"ProvisioningState/succeeded", "PowerState/running"}, want: InstanceRunning},
+		{codes: []string{"ProvisioningState/succeeded", "PowerState/deallocated"}, want: InstanceStopped},
+		{codes: []string{"ProvisioningState/succeeded", "PowerState/stopped"}, want: InstanceStopped},
+		{codes: []string{"ProvisioningState/succeeded", "PowerState/deallocating"}, want: InstanceStopping},
+		{codes: []string{"ProvisioningState/creating", "PowerState/starting"}, want: InstancePending},
+		{codes: []string{"ProvisioningState/deleting", "PowerState/running"}, want: InstanceTerminating},
+		{codes: []string{"ProvisioningState/failed/AllocationFailed"}, want: InstanceFailed},
+		{codes: []string{"ProvisioningState/succeeded"}, want: InstanceUnknown},
+		{codes: nil, want: InstanceUnknown},
+	}
+	for _, tt := range tests {
+		var statuses []*armcompute.InstanceViewStatus
+		for _, code := range tt.codes {
+			statuses = append(statuses, &armcompute.InstanceViewStatus{Code: to.Ptr(code)})
+		}
+		if got := azureInstanceState(statuses); got != tt.want {
+			t.Errorf("azureInstanceState(%v) = %q, want %q", tt.codes, got, tt.want)
+		}
+	}
+}
+
+func TestVMParametersDeleteOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    devopsv1.AzureConfigSpec
+		deleteNIC bool
+	}{
+		{name: "NIC created for the VM", config: devopsv1.AzureConfigSpec{SubnetID: "subnet"}, deleteNIC: true},
+		{name: "existing NIC", config: devopsv1.AzureConfigSpec{NetworkInterfaceID: "nic"}, deleteNIC: false},
+	}
+	for _, tt := range tests {
+		p := &azureProvider{config: tt.config}
+		vm := p.vmParameters(InstanceRequest{Name: "web-0"}, "nic-id", nil)
+
+		osDisk := vm.Properties.StorageProfile.OSDisk
+		if osDisk == nil || osDisk.DeleteOption == nil || *osDisk.DeleteOption != armcompute.DiskDeleteOptionTypesDelete {
+			t.Errorf("%s: OS disk is not deleted with the VM", tt.name)
+		}
+		nic := vm.Properties.NetworkProfile.NetworkInterfaces[0]
+		deleteNIC := nic.Properties != nil && nic.Properties.DeleteOption != nil &&
+			*nic.Properties.DeleteOption == armcompute.DeleteOptionsDelete
+		if deleteNIC != tt.deleteNIC {
+			t.Errorf("%s: NIC deleted with the VM = %v, want %v", tt.name, deleteNIC, tt.deleteNIC)
+		}
+	}
+}
+
+func TestVMParametersCustomData(t *testing.T) {
+	p := &azureProvider{config: devopsv1.AzureConfigSpec{AdminUsername: "azureuser"}}
+
+	vm := p.vmParameters(InstanceRequest{Name: "web-0"}, "nic-id", nil)
+	if vm.Properties.OSProfile.CustomData != nil {
+		t.Errorf("CustomData = %q without a bootstrap, want nil", *vm.Properties.OSProfile.CustomData)
+	}
+
+	vm = p.vmParameters(InstanceRequest{Name: "web-0", UserData: "#cloud-config\n"}, "nic-id", nil)
+	want := base64.StdEncoding.EncodeToString([]byte("#cloud-config\n"))
+	if got := vm.Properties.OSProfile.CustomData; got == nil || *got != want {
+		t.Errorf("CustomData = %v, want %q", got, want)
+	}
+}
+
+func TestLinuxConfiguration(t *testing.T) {
+	tests := []struct {
+		name            string
+		password        string
+		disablePassword bool
+	}{
+		{name: "keys only", disablePassword: true},
+		{name: "keys and password", password: "s3cret!Passw0rd", disablePassword: false},
+	}
+	for _, tt := range tests {
+		p := &azureProvider{config: devopsv1.AzureConfigSpec{AdminUsername: "azureuser"}}
+		config := p.linuxConfiguration(InstanceRequest{
+			AdminPassword: tt.password,
+			SSHPublicKeys: []string{"ssh-ed25519 AAAA1", "ssh-rsa AAAA2"},
+		})
+		if config.DisablePasswordAuthentication == nil || *config.DisablePasswordAuthentication != tt.disablePassword {
+			t.Errorf("%s: DisablePasswordAuthentication = %v, want %v",
+				tt.name, config.DisablePasswordAuthentication, tt.disablePassword)
+		}
+		keys := config.SSH.PublicKeys
+		if len(keys) != 2 || *keys[0].KeyData != "ssh-ed25519 AAAA1" || *keys[1].KeyData != "ssh-rsa AAAA2" {
+			t.Fatalf("%s: PublicKeys = %v", tt.name, keys)
+		}
+		for _, key := range keys {
+			if *key.Path != "/home/azureuser/.ssh/authorized_keys" {
+				t.Errorf("%s: Path = %q", tt.name, *key.Path)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package cloudclients
+
+import (
+	"errors"
+	"fmt"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+)
+
+// Keys read from the credentials Secret referenced by a provider spec.
+const (
+	// GCPCredentialsKey holds a service account key in JSON form.
+	GCPCredentialsKey = "credentials.json"
+
+	// AWSAccessKeyIDKey and AWSSecretAccessKeyKey hold a static IAM access key.
+	AWSAccessKeyIDKey     = "accessKeyID"
+	AWSSecretAccessKeyKey = "secretAccessKey"
+	// AWSSessionTokenKey optionally holds a session token for temporary keys.
+	AWSSessionTokenKey = "sessionToken"
+
+	// AzureTenantIDKey and AzureClientIDKey identify the service principal.
+	AzureTenantIDKey = "tenantID"
+	AzureClientIDKey = "clientID"
+	// AzureClientSecretKey holds the service principal's client secret.
+	AzureClientSecretKey = "clientSecret"
+	// AzureClientCertificateKey holds a PEM or PKCS#12 certificate with its
+	// private key, used when no client secret is set.
+	AzureClientCertificateKey = "clientCertificate"
+	// AzureClientCertificatePasswordKey optionally holds the certificate password.
+	AzureClientCertificatePasswordKey = "clientCertificatePassword"
+)
+
+// ErrInvalidCredentials is returned when a credentials Secret cannot be used
+// to authenticate, e.g. because a required key is missing.
+var ErrInvalidCredentials = errors.New("invalid cloud credentials")
+
+// IsCredentialsError reports whether err means the MyResource's credentials
+// cannot be used: the credentials Secret is missing or malformed, or a cloud
+// API rejected the identity or permissions it grants.
+func IsCredentialsError(err error) bool {
+	return errors.Is(err, ErrInvalidCredentials) || isAWSAuthError(err) || isGCPAuthError(err) || isAzureAuthError(err)
+}
+
+// Credentials is the data of the Secret referenced by a provider spec. A nil
+// Credentials makes a provider fall back to the controller's own identity.
+type Credentials map[string][]byte
+
+// CredentialsSecretName returns the name of the credentials Secret referenced
+// by whichever provider the spec configures, or "" if none is referenced.
+func CredentialsSecretName(spec *devopsv1.MyResourceSpec) string {
+	switch {
+	case spec.GCPConfig != nil && spec.GCPConfig.CredentialsSecretRef != "":
+		return spec.GCPConfig.CredentialsSecretRef
+	case spec.AWSConfig != nil && spec.AWSConfig.CredentialsSecretRef != "":
+		return spec.AWSConfig.CredentialsSecretRef
+	case spec.AzureConfig != nil && spec.AzureConfig.CredentialsSecretRef != "":
+		return spec.AzureConfig.CredentialsSecretRef
+	default:
+		return ""
+	}
+}
+
+// required returns the value of key, or an ErrInvalidCredentials error if it is missing or empty.
+func (c Credentials) required(key string) ([]byte, error) {
+	value := c[key]
+	if len(value) == 0 {
+		return nil, fmt.Errorf("credentials secret has no %q key: %w", key, ErrInvalidCredentials)
+	}
+	return value, nil
+}
@@ -0,0 +1,50 @@
+package cloudclients
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsCredentialsError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "missing key", err: fmt.Errorf("credentials secret has no %q key: %w", "clientID", ErrInvalidCredentials), want: true},
+		{name: "unrelated", err: errors.New("connection reset by peer")},
+		{
+			name: "EC2 auth failure",
+			err:  fmt.Errorf("failed to list EC2 instances: %w", awserr.New("AuthFailure", "AWS was not able to validate the provided access credentials", nil)),
+			want: true,
+		},
+		{
+			name: "EC2 forbidden",
+			err:  awserr.NewRequestFailure(awserr.New("Blocked", "denied", nil), http.StatusForbidden, "1"),
+			want: true,
+		},
+		{name: "EC2 throttling", err: awserr.NewRequestFailure(awserr.New("RequestLimitExceeded", "slow down", nil), http.StatusServiceUnavailable, "1")},
+		{name: "GCE forbidden", err: fmt.Errorf("failed to list GCE instances: %w", &googleapi.Error{Code: http.StatusForbidden}), want: true},
+		{name: "GCE not found", err: &googleapi.Error{Code: http.StatusNotFound}},
+		{
+			name: "Google token refused",
+			err:  &url.Error{Op: "Get", URL: "https://compute.googleapis.com", Err: &oauth2.RetrieveError{ErrorCode: "invalid_grant"}},
+			want: true,
+		},
+		{name: "Azure unauthorized", err: fmt.Errorf("failed to list VMs: %w", &azcore.ResponseError{StatusCode: http.StatusUnauthorized}), want: true},
+		{name: "Azure conflict", err: &azcore.ResponseError{StatusCode: http.StatusConflict}},
+	}
+	for _, tt := range tests {
+		if got := IsCredentialsError(tt.err); got != tt.want {
+			t.Errorf("%s: IsCredentialsError() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
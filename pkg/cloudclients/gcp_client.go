@@ -2,161 +2,503 @@ package cloudclients
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
 	"time"
 
+	"golang.org/x/oauth2"
 	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 
 	// Import CRD package to use GCPConfigSpec
 	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
 )
 
-// UpdateGCPInstances ensures the running GCE instances match 'desiredCount'.
-// If currentCount < desiredCount, create new instances. If currentCount > desiredCount, delete.
-func UpdateGCPInstances(
-	ctx context.Context,
-	config devopsv1.GCPConfigSpec,
-	currentCount int,
-	desiredCount int,
-) error {
-	// 1. Init GCE client with the default app cred.
-	svc, err := compute.NewService(ctx, option.WithScopes(compute.ComputeScope))
+// The image family booted when the spec names no image.
+const (
+	defaultGCPImageProject = "debian-cloud"
+	defaultGCPImageFamily  = "debian-11"
+)
+
+// gcpProvider manages GCE instances for a single MyResource.
+type gcpProvider struct {
+	svc    *compute.Service
+	config devopsv1.GCPConfigSpec
+	owner  Owner
+}
+
+// NewGCPProvider builds a Provider backed by Compute Engine. It authenticates with
+// the service account key in creds, or the application default credentials if creds is nil.
+func NewGCPProvider(ctx context.Context, res *devopsv1.MyResource, creds Credentials) (Provider, error) {
+	if res.Spec.GCPConfig == nil {
+		return nil, fmt.Errorf("gcpConfig is not set")
+	}
+
+	opts := []option.ClientOption{option.WithScopes(compute.ComputeScope)}
+	if creds != nil {
+		keyJSON, err := creds.required(GCPCredentialsKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, option.WithCredentialsJSON(keyJSON))
+	}
+
+	svc, err := compute.NewService(ctx, opts...)
 	if err != nil {
-		return fmt.Errorf("failed to create compute service: %w", err)
+		return nil, fmt.Errorf("failed to create compute service: %w", err)
 	}
 
-	// 2. Calculate how many instances to add or remove.
-	diff := desiredCount - currentCount
-	if diff > 0 {
-		for i := 0; i < diff; i++ {
-			if err := createGCEInstance(ctx, svc, config); err != nil {
-				return err
-			}
+	return &gcpProvider{svc: svc, config: *res.Spec.GCPConfig, owner: OwnerOf(res)}, nil
+}
+
+func (p *gcpProvider) Name() string {
+	return ProviderGCP
+}
+
+// ListInstances returns the instances in the configured zone labelled as owned by the MyResource.
+func (p *gcpProvider) ListInstances(ctx context.Context) ([]Instance, error) {
+	instances, err := p.listByIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var owned []Instance
+	for _, instance := range instances {
+		if ownedBy(instance.Tags, p.owner.Labels()) {
+			owned = append(owned, instance)
 		}
-	} else if diff < 0 {
-		toDelete := -diff
-		if err := deleteGCEInstances(ctx, svc, config, toDelete); err != nil {
-			return err
+	}
+	return owned, nil
+}
+
+// ListOrphans returns the instances released by an earlier MyResource with the same namespace and name.
+func (p *gcpProvider) ListOrphans(ctx context.Context) ([]Instance, error) {
+	instances, err := p.listByIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []Instance
+	for _, instance := range instances {
+		if orphanedBy(instance.Tags, gceLabels(p.owner.identityTags())) {
+			orphans = append(orphans, instance)
 		}
 	}
+	return orphans, nil
+}
 
-	return nil
+// listByIdentity returns the instances in the configured zone labelled with the MyResource namespace and name.
+func (p *gcpProvider) listByIdentity(ctx context.Context) ([]Instance, error) {
+	images, err := p.bootDiskImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []Instance
+	err = p.svc.Instances.List(p.config.ProjectID, p.config.Zone).
+		Filter(p.identityFilter()).
+		Pages(ctx, func(page *compute.InstanceList) error {
+			for _, inst := range page.Items {
+				instances = append(instances, p.toInstance(inst, images))
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list GCE instances: %w", err)
+	}
+	return instances, nil
 }
 
-// createGCEInstance creates a single GCE instance with the specified config and waits for the operation to reach "DONE" status before returning.
-func createGCEInstance(
-    ctx context.Context,
-    svc *compute.Service,
-    config devopsv1.GCPConfigSpec,
-) error {
-    rand.Seed(time.Now().UnixNano())
-    // generate random name for instance for now
-    instanceName := fmt.Sprintf("myresource-%d", rand.Intn(1000000))
-
-    // Build the Instance object, specifying machine type, disk image, network, etc.
-    instance := &compute.Instance{
-        Name:        instanceName,
-        MachineType: fmt.Sprintf("zones/%s/machineTypes/%s", config.Zone, config.MachineType),
-        Disks: []*compute.AttachedDisk{
-            {
-                AutoDelete: true,
-                Boot:       true,
-                Type:       "PERSISTENT",
-                InitializeParams: &compute.AttachedDiskInitializeParams{
-                    SourceImage: "projects/debian-cloud/global/images/family/debian-11",
-                },
-            },
-        },
-        NetworkInterfaces: []*compute.NetworkInterface{
-            {
-                AccessConfigs: []*compute.AccessConfig{
-                    {Type: "ONE_TO_ONE_NAT"},
-                },
-            },
-			
-        },
-    }
-
-    log.Printf("[GCP] Creating instance: %s (machineType=%s, zone=%s)",
-        instanceName, config.MachineType, config.Zone)
-
-    // Insert the instance (asynchronous oper)
-    op, err := svc.Instances.Insert(config.ProjectID, config.Zone, instance).
-        Context(ctx).Do()
-    if err != nil {
-        return fmt.Errorf("failed to create GCE instance: %w", err)
-    }
-
-    log.Printf("[GCP] Create Operation %s - initial status: %s", op.Name, op.Status)
-
-    // Wait for the operation to reach DONE status before returning
-    if err := waitForZonalOp(ctx, svc, config.ProjectID, config.Zone, op.Name); err != nil {
-        return fmt.Errorf("failed waiting for insert operation %s to complete: %w", op.Name, err)
-    }
-
-    log.Printf("[GCP] Instance %s creation is DONE", instanceName)
-    return nil
-}
-
-// deleteGCEInstances removes 'numToDelete' of your operator-managed instances.
-func deleteGCEInstances(
-	ctx context.Context,
-	svc *compute.Service,
-	config devopsv1.GCPConfigSpec,
-	numToDelete int,
-) error {
-	instanceList, err := svc.Instances.List(config.ProjectID, config.Zone).
-		Context(ctx).Do()
+// bootDiskImages maps the self links of the MyResource's disks to the image
+// each was created from. Instances only reference their disks, so this saves
+// a disk lookup per instance.
+func (p *gcpProvider) bootDiskImages(ctx context.Context) (map[string]string, error) {
+	images := make(map[string]string)
+	err := p.svc.Disks.List(p.config.ProjectID, p.config.Zone).
+		Filter(p.identityFilter()).
+		Pages(ctx, func(page *compute.DiskList) error {
+			for _, disk := range page.Items {
+				images[disk.SelfLink] = disk.SourceImage
+			}
+			return nil
+		})
 	if err != nil {
-		return fmt.Errorf("failed to list GCE instances: %w", err)
+		return nil, fmt.Errorf("failed to list GCE disks: %w", err)
+	}
+	return images, nil
+}
+
+// identityFilter returns a list filter matching the MyResource namespace and name labels.
+func (p *gcpProvider) identityFilter() string {
+	var filters []string
+	for key, value := range gceLabels(p.owner.identityTags()) {
+		filters = append(filters, fmt.Sprintf("(labels.%s = %q)", key, value))
+	}
+	sort.Strings(filters)
+	return strings.Join(filters, " AND ")
+}
+
+// CreateInstance creates a single GCE instance with the specified config and waits for the operation to reach "DONE" status before returning.
+func (p *gcpProvider) CreateInstance(ctx context.Context, req InstanceRequest) (*Instance, error) {
+	instanceName := req.Name
+
+	image := req.Image
+	if image == "" {
+		var err error
+		if image, err = p.ResolveImage(ctx); err != nil {
+			return nil, err
+		}
 	}
 
-	// Filter instances by name pattern
-	var candidateInstances []string
-	for _, inst := range instanceList.Items {
-		if len(inst.Name) >= 11 && inst.Name[:11] == "myresource-" {
-			candidateInstances = append(candidateInstances, inst.Name)
+	// Build the Instance object, specifying machine type, disk image, network, etc.
+	labels := p.instanceLabels(req.Tags)
+	instance := &compute.Instance{
+		Name:        instanceName,
+		Labels:      labels,
+		MachineType: fmt.Sprintf("zones/%s/machineTypes/%s", p.config.Zone, p.config.MachineType),
+		Disks: []*compute.AttachedDisk{
+			{
+				AutoDelete: true,
+				Boot:       true,
+				Type:       "PERSISTENT",
+				// Labelled like the instance so bootDiskImages can find it.
+				InitializeParams: p.bootDiskParams(image, labels),
+			},
+		},
+		NetworkInterfaces: []*compute.NetworkInterface{p.networkInterface()},
+	}
+	instance.Metadata = instanceMetadata(req)
+	if len(p.config.NetworkTags) > 0 {
+		instance.Tags = &compute.Tags{Items: p.config.NetworkTags}
+	}
+	if sa := p.config.ServiceAccount; sa != nil {
+		instance.ServiceAccounts = []*compute.ServiceAccount{{Email: sa.Email, Scopes: sa.Scopes}}
+	}
+	if shielded := p.config.ShieldedVM; shielded != nil {
+		instance.ShieldedInstanceConfig = &compute.ShieldedInstanceConfig{
+			EnableSecureBoot:          shielded.SecureBoot,
+			EnableVtpm:                shielded.VTPM,
+			EnableIntegrityMonitoring: shielded.IntegrityMonitoring,
 		}
 	}
 
-	if len(candidateInstances) < numToDelete {
-		numToDelete = len(candidateInstances)
+	log.Printf("[GCP] Creating instance: %s (machineType=%s, zone=%s)",
+		instanceName, p.config.MachineType, p.config.Zone)
+
+	// Insert the instance (asynchronous oper). GCE ignores an insert retried
+	// with the same request ID, and names are unique per zone.
+	call := p.svc.Instances.Insert(p.config.ProjectID, p.config.Zone, instance).Context(ctx)
+	if req.ClientToken != "" {
+		call = call.RequestId(req.ClientToken)
+	}
+	op, err := call.Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+		return p.existingInstance(ctx, instanceName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCE instance: %w", err)
+	}
+
+	log.Printf("[GCP] Create Operation %s - initial status: %s", op.Name, op.Status)
+
+	// Wait for the operation to reach DONE status before returning
+	if err := p.waitForZonalOp(ctx, op.Name); err != nil {
+		return nil, fmt.Errorf("failed waiting for insert operation %s to complete: %w", op.Name, err)
+	}
+
+	log.Printf("[GCP] Instance %s creation is DONE", instanceName)
+	return p.DescribeInstance(ctx, p.instanceID(instanceName))
+}
+
+// existingInstance returns the instance with the given name if the MyResource owns it.
+func (p *gcpProvider) existingInstance(ctx context.Context, name string) (*Instance, error) {
+	instance, err := p.DescribeInstance(ctx, p.instanceID(name))
+	if err != nil {
+		return nil, err
+	}
+	if !ownedBy(instance.Tags, p.owner.Labels()) {
+		return nil, fmt.Errorf("instance %s already exists: %w", name, ErrNotOwned)
+	}
+	log.Printf("[GCP] Instance %s already exists; adopting it", name)
+	return instance, nil
+}
+
+// ImageSource returns the configured image, or the URL of the configured image family.
+func (p *gcpProvider) ImageSource() string {
+	if p.config.Image != "" {
+		return p.config.Image
+	}
+	project, family := p.imageFamily()
+	return fmt.Sprintf("projects/%s/global/images/family/%s", project, family)
+}
+
+// ResolveImage returns the configured image, or the latest image in the configured family.
+func (p *gcpProvider) ResolveImage(ctx context.Context) (string, error) {
+	if p.config.Image != "" {
+		return p.config.Image, nil
+	}
+	project, family := p.imageFamily()
+	image, err := p.svc.Images.GetFromFamily(project, family).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve image family %s/%s: %w", project, family, err)
 	}
+	log.Printf("[GCP] Resolved image family %s/%s to %s", project, family, image.SelfLink)
+	return image.SelfLink, nil
+}
 
-	toDeleteList := candidateInstances[len(candidateInstances)-numToDelete:]
+// imageFamily returns the project and name of the image family to boot from.
+func (p *gcpProvider) imageFamily() (string, string) {
+	if p.config.ImageFamily == "" {
+		return defaultGCPImageProject, defaultGCPImageFamily
+	}
+	if p.config.ImageProject == "" {
+		return p.config.ProjectID, p.config.ImageFamily
+	}
+	return p.config.ImageProject, p.config.ImageFamily
+}
 
-	log.Printf("[GCP] Deleting %d instance(s): %v", numToDelete, toDeleteList)
+// DeleteInstance deletes the instance identified by its relative resource name and waits for the operation to finish.
+func (p *gcpProvider) DeleteInstance(ctx context.Context, id string) error {
+	instance, err := p.DescribeInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ownedBy(instance.Tags, p.owner.Labels()) {
+		return fmt.Errorf("refusing to delete GCE instance %s: %w", id, ErrNotOwned)
+	}
 
-	for _, instanceName := range toDeleteList {
-		op, err := svc.Instances.Delete(config.ProjectID, config.Zone, instanceName).
+	instanceName := instance.Name
+	op, err := p.svc.Instances.Delete(p.config.ProjectID, p.config.Zone, instanceName).
+		Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to delete instance %s: %w", instanceName, err)
+	}
+	log.Printf("[GCP] Delete Operation %s for instance %s - status: %s",
+		op.Name, instanceName, op.Status)
+
+	// GCE keeps listing the instance until the delete finishes, so wait for it
+	// to avoid counting it twice.
+	if err := p.waitForZonalOp(ctx, op.Name); err != nil {
+		return fmt.Errorf("failed waiting for delete operation %s to complete: %w", op.Name, err)
+	}
+	return nil
+}
+
+// DescribeInstance fetches the instance identified by its relative resource name.
+func (p *gcpProvider) DescribeInstance(ctx context.Context, id string) (*Instance, error) {
+	inst, err := p.svc.Instances.Get(p.config.ProjectID, p.config.Zone, path.Base(id)).
+		Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get GCE instance %s: %w", id, err)
+	}
+
+	images := make(map[string]string)
+	for _, disk := range inst.Disks {
+		if !disk.Boot {
+			continue
+		}
+		bootDisk, err := p.svc.Disks.Get(p.config.ProjectID, p.config.Zone, path.Base(disk.Source)).
 			Context(ctx).Do()
 		if err != nil {
-			return fmt.Errorf("failed to delete instance %s: %w", instanceName, err)
+			return nil, fmt.Errorf("failed to get boot disk of GCE instance %s: %w", id, err)
 		}
-		log.Printf("[GCP] Delete Operation %s for instance %s - status: %s",
-			op.Name, instanceName, op.Status)
+		images[bootDisk.SelfLink] = bootDisk.SourceImage
+	}
+
+	instance := p.toInstance(inst, images)
+	return &instance, nil
+}
+
+// UpdateTags sets and removes labels on the instance. Values in set are
+// converted to valid label values.
+func (p *gcpProvider) UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error {
+	instanceName := path.Base(id)
+	inst, err := p.svc.Instances.Get(p.config.ProjectID, p.config.Zone, instanceName).
+		Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get GCE instance %s: %w", instanceName, err)
 	}
 
+	labels := make(map[string]string, len(inst.Labels)+len(set))
+	for key, value := range inst.Labels {
+		labels[key] = value
+	}
+	for key, value := range gceLabels(set) {
+		labels[key] = value
+	}
+	for _, key := range remove {
+		delete(labels, key)
+	}
+
+	// The fingerprint makes the update fail rather than clobber a concurrent change.
+	op, err := p.svc.Instances.SetLabels(p.config.ProjectID, p.config.Zone, instanceName,
+		&compute.InstancesSetLabelsRequest{
+			Labels:           labels,
+			LabelFingerprint: inst.LabelFingerprint,
+		}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to set labels on GCE instance %s: %w", instanceName, err)
+	}
+	if err := p.waitForZonalOp(ctx, op.Name); err != nil {
+		return fmt.Errorf("failed waiting for setLabels operation %s to complete: %w", op.Name, err)
+	}
+
+	log.Printf("[GCP] Updated labels on instance %s", instanceName)
 	return nil
 }
 
+// NormalizeName rewrites name into a valid GCE instance name.
+func (p *gcpProvider) NormalizeName(name string) string {
+	return dnsLabel(name, gceNameMaxLength)
+}
+
+// instanceLabels returns the requested tags as labels, merged with the
+// configured labels and the ownership labels, which take precedence.
+func (p *gcpProvider) instanceLabels(requested map[string]string) map[string]string {
+	labels := p.NormalizeTags(requested)
+	for key, value := range p.owner.Labels() {
+		labels[key] = value
+	}
+	return labels
+}
 
-func waitForZonalOp(
-	ctx context.Context,
-	svc *compute.Service,
-	projectID string,
-	zone string,
-	operationName string,
-) error {
-	log.Printf("[GCP] Waiting for operation %s in zone %s to complete...", operationName, zone)
+// NormalizeTags converts tags into GCE labels and adds the labels configured
+// in gcpConfig, which take precedence over tags with the same key.
+func (p *gcpProvider) NormalizeTags(tags map[string]string) map[string]string {
+	labels := gceLabels(tags)
+	for key, value := range gceLabels(p.config.Labels) {
+		labels[key] = value
+	}
+	return labels
+}
+
+// bootDiskParams returns the boot disk settings for a new instance.
+func (p *gcpProvider) bootDiskParams(image string, labels map[string]string) *compute.AttachedDiskInitializeParams {
+	params := &compute.AttachedDiskInitializeParams{
+		Labels:      labels,
+		SourceImage: image,
+	}
+	if disk := p.config.BootDisk; disk != nil {
+		params.DiskSizeGb = disk.SizeGB
+		if disk.Type != "" {
+			params.DiskType = fmt.Sprintf("zones/%s/diskTypes/%s", p.config.Zone, disk.Type)
+		}
+	}
+	return params
+}
+
+// networkInterface returns the network interface for a new instance. Network
+// and subnetwork names are expanded to partial URLs; full URLs are passed through.
+func (p *gcpProvider) networkInterface() *compute.NetworkInterface {
+	nic := &compute.NetworkInterface{}
+	if network := p.config.Network; network != "" {
+		if !strings.Contains(network, "/") {
+			network = "global/networks/" + network
+		}
+		nic.Network = network
+	}
+	if subnetwork := p.config.Subnetwork; subnetwork != "" {
+		if !strings.Contains(subnetwork, "/") {
+			subnetwork = fmt.Sprintf("regions/%s/subnetworks/%s", p.region(), subnetwork)
+		}
+		nic.Subnetwork = subnetwork
+	}
+	if p.config.ExternalIP == nil || *p.config.ExternalIP {
+		nic.AccessConfigs = []*compute.AccessConfig{{Type: "ONE_TO_ONE_NAT"}}
+	}
+	return nic
+}
+
+// region returns the configured region, or the region the zone belongs to.
+func (p *gcpProvider) region() string {
+	// A GCE zone is its region plus a suffix, e.g. us-central1-a.
+	if i := strings.LastIndex(p.config.Zone, "-"); p.config.Region == "" && i > 0 {
+		return p.config.Zone[:i]
+	}
+	return p.config.Region
+}
+
+// instanceMetadata returns the metadata items carrying the bootstrap script and SSH keys of req.
+func instanceMetadata(req InstanceRequest) *compute.Metadata {
+	var items []*compute.MetadataItems
+	if req.UserData != "" {
+		items = append(items, &compute.MetadataItems{Key: "startup-script", Value: &req.UserData})
+	}
+	if len(req.SSHPublicKeys) > 0 {
+		lines := make([]string, 0, len(req.SSHPublicKeys))
+		for _, key := range req.SSHPublicKeys {
+			lines = append(lines, req.SSHUsername+":"+key)
+		}
+		sshKeys := strings.Join(lines, "\n")
+		items = append(items, &compute.MetadataItems{Key: "ssh-keys", Value: &sshKeys})
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return &compute.Metadata{Items: items}
+}
+
+// instanceID returns the relative resource name GCE uses to address an instance.
+func (p *gcpProvider) instanceID(name string) string {
+	return fmt.Sprintf("projects/%s/zones/%s/instances/%s", p.config.ProjectID, p.config.Zone, name)
+}
+
+// toInstance converts a GCE instance; images maps boot disk self links to their source image.
+func (p *gcpProvider) toInstance(inst *compute.Instance, images map[string]string) Instance {
+	instance := Instance{
+		ID:    p.instanceID(inst.Name),
+		Name:  inst.Name,
+		State: gcpInstanceState(inst.Status),
+		Tags:  inst.Labels,
+		Zone:  path.Base(inst.Zone),
+	}
+	if created, err := time.Parse(time.RFC3339, inst.CreationTimestamp); err == nil {
+		instance.CreatedAt = created
+	}
+	if len(inst.NetworkInterfaces) > 0 {
+		nic := inst.NetworkInterfaces[0]
+		instance.PrivateIP = nic.NetworkIP
+		if len(nic.AccessConfigs) > 0 {
+			instance.PublicIP = nic.AccessConfigs[0].NatIP
+		}
+	}
+	for _, disk := range inst.Disks {
+		if disk.Boot {
+			instance.Image = images[disk.Source]
+		}
+	}
+	return instance
+}
+
+// gcpInstanceState maps a GCE instance status onto the provider-neutral states.
+func gcpInstanceState(status string) string {
+	switch status {
+	case "PROVISIONING", "STAGING", "REPAIRING":
+		return InstancePending
+	case "RUNNING":
+		return InstanceRunning
+	case "STOPPING", "SUSPENDING":
+		return InstanceStopping
+	// GCE reports a stopped instance as TERMINATED; it still exists and can be restarted.
+	case "STOPPED", "SUSPENDED", "TERMINATED":
+		return InstanceStopped
+	default:
+		return InstanceUnknown
+	}
+}
+
+func (p *gcpProvider) waitForZonalOp(ctx context.Context, operationName string) error {
+	log.Printf("[GCP] Waiting for operation %s in zone %s to complete...", operationName, p.config.Zone)
 
 	// Poll the operation until it is "DONE"
 	for {
 		// Get the operation status
-		op, err := svc.ZoneOperations.Get(projectID, zone, operationName).Context(ctx).Do()
+		op, err := p.svc.ZoneOperations.Get(p.config.ProjectID, p.config.Zone, operationName).Context(ctx).Do()
 		if err != nil {
 			return fmt.Errorf("failed to get operation %s: %w", operationName, err)
 		}
@@ -175,4 +517,15 @@ func waitForZonalOp(
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// isGCPAuthError reports whether err is GCE rejecting the caller's identity or
+// permissions, or Google refusing to issue it a token.
+func isGCPAuthError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden
+	}
+	var tokenErr *oauth2.RetrieveError
+	return errors.As(err, &tokenErr)
+}
@@ -0,0 +1,126 @@
+package cloudclients
+
+import (
+	"testing"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+)
+
+func TestNetworkInterface(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name           string
+		config         devopsv1.GCPConfigSpec
+		wantNetwork    string
+		wantSubnetwork string
+		wantExternalIP bool
+	}{
+		{
+			name:           "defaults",
+			config:         devopsv1.GCPConfigSpec{Zone: "us-central1-a"},
+			wantExternalIP: true,
+		},
+		{
+			name:           "no external IP",
+			config:         devopsv1.GCPConfigSpec{Zone: "us-central1-a", ExternalIP: &disabled},
+			wantExternalIP: false,
+		},
+		{
+			name:           "names are expanded",
+			config:         devopsv1.GCPConfigSpec{Zone: "us-central1-a", Network: "prod", Subnetwork: "web"},
+			wantNetwork:    "global/networks/prod",
+			wantSubnetwork: "regions/us-central1/subnetworks/web",
+			wantExternalIP: true,
+		},
+		{
+			name: "region takes precedence over the zone",
+			config: devopsv1.GCPConfigSpec{
+				Region: "us-central1", Zone: "us-central1-a", Subnetwork: "web", ExternalIP: &disabled,
+			},
+			wantSubnetwork: "regions/us-central1/subnetworks/web",
+		},
+		{
+			name: "URLs are passed through",
+			config: devopsv1.GCPConfigSpec{
+				Zone:       "us-central1-a",
+				Network:    "projects/shared/global/networks/prod",
+				Subnetwork: "projects/shared/regions/us-central1/subnetworks/web",
+				ExternalIP: &disabled,
+			},
+			wantNetwork:    "projects/shared/global/networks/prod",
+			wantSubnetwork: "projects/shared/regions/us-central1/subnetworks/web",
+		},
+	}
+	for _, tt := range tests {
+		p := &gcpProvider{config: tt.config}
+		nic := p.networkInterface()
+		if nic.Network != tt.wantNetwork {
+			t.Errorf("%s: Network = %q, want %q", tt.name, nic.Network, tt.wantNetwork)
+		}
+		if nic.Subnetwork != tt.wantSubnetwork {
+			t.Errorf("%s: Subnetwork = %q, want %q", tt.name, nic.Subnetwork, tt.wantSubnetwork)
+		}
+		if got := len(nic.AccessConfigs) > 0; got != tt.wantExternalIP {
+			t.Errorf("%s: external IP = %v, want %v", tt.name, got, tt.wantExternalIP)
+		}
+	}
+}
+
+func TestBootDiskParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		bootDisk *devopsv1.GCPBootDisk
+		wantSize int64
+		wantType string
+	}{
+		{name: "image defaults", bootDisk: nil},
+		{name: "size only", bootDisk: &devopsv1.GCPBootDisk{SizeGB: 50}, wantSize: 50},
+		{
+			name:     "size and type",
+			bootDisk: &devopsv1.GCPBootDisk{SizeGB: 100, Type: "pd-ssd"},
+			wantSize: 100,
+			wantType: "zones/us-central1-a/diskTypes/pd-ssd",
+		},
+	}
+	for _, tt := range tests {
+		p := &gcpProvider{config: devopsv1.GCPConfigSpec{Zone: "us-central1-a", BootDisk: tt.bootDisk}}
+		params := p.bootDiskParams("projects/debian-cloud/global/images/debian-11", map[string]string{"app": "web"})
+		if params.SourceImage != "projects/debian-cloud/global/images/debian-11" {
+			t.Errorf("%s: SourceImage = %q", tt.name, params.SourceImage)
+		}
+		if params.Labels["app"] != "web" {
+			t.Errorf("%s: Labels = %v", tt.name, params.Labels)
+		}
+		if params.DiskSizeGb != tt.wantSize {
+			t.Errorf("%s: DiskSizeGb = %d, want %d", tt.name, params.DiskSizeGb, tt.wantSize)
+		}
+		if params.DiskType != tt.wantType {
+			t.Errorf("%s: DiskType = %q, want %q", tt.name, params.DiskType, tt.wantType)
+		}
+	}
+}
+
+func TestInstanceMetadata(t *testing.T) {
+	if metadata := instanceMetadata(InstanceRequest{}); metadata != nil {
+		t.Errorf("instanceMetadata() without bootstrap or keys = %v, want nil", metadata)
+	}
+
+	metadata := instanceMetadata(InstanceRequest{
+		UserData:      "#!/bin/sh\necho hi\n",
+		SSHUsername:   "ops",
+		SSHPublicKeys: []string{"ssh-ed25519 AAAA1 a@b", "ssh-rsa AAAA2"},
+	})
+	want := map[string]string{
+		// GCE runs the startup script as given; no encoding is applied.
+		"startup-script": "#!/bin/sh\necho hi\n",
+		"ssh-keys":       "ops:ssh-ed25519 AAAA1 a@b\nops:ssh-rsa AAAA2",
+	}
+	if metadata == nil || len(metadata.Items) != len(want) {
+		t.Fatalf("instanceMetadata() = %v, want %d items", metadata, len(want))
+	}
+	for _, item := range metadata.Items {
+		if item.Value == nil || *item.Value != want[item.Key] {
+			t.Errorf("metadata %q = %v, want %q", item.Key, item.Value, want[item.Key])
+		}
+	}
+}
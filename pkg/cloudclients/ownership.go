@@ -0,0 +1,110 @@
+package cloudclients
+
+import (
+	"errors"
+	"strings"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+)
+
+// Ownership tag keys written on every instance. They are valid EC2 tag keys,
+// GCE label keys and Azure tag names alike.
+const (
+	OwnerNamespaceTag = "myresource-namespace"
+	OwnerNameTag      = "myresource-name"
+	OwnerUIDTag       = "myresource-uid"
+)
+
+// gceLabelMaxLength is the maximum length of a GCE label value.
+const gceLabelMaxLength = 63
+
+// ErrNotOwned is returned when an operation targets an instance that does not
+// carry the ownership tags of the MyResource the provider was built for.
+var ErrNotOwned = errors.New("instance is not owned by this MyResource")
+
+// Owner identifies the MyResource a set of instances belongs to.
+type Owner struct {
+	Namespace string
+	Name      string
+	UID       string
+}
+
+// OwnerOf returns the Owner for the given MyResource.
+func OwnerOf(res *devopsv1.MyResource) Owner {
+	return Owner{
+		Namespace: res.Namespace,
+		Name:      res.Name,
+		UID:       string(res.UID),
+	}
+}
+
+// Tags returns the ownership tags as written on EC2 instances and Azure VMs.
+func (o Owner) Tags() map[string]string {
+	return map[string]string{
+		OwnerNamespaceTag: o.Namespace,
+		OwnerNameTag:      o.Name,
+		OwnerUIDTag:       o.UID,
+	}
+}
+
+// Labels returns the ownership tags in the restricted form GCE accepts for labels.
+func (o Owner) Labels() map[string]string {
+	return gceLabels(o.Tags())
+}
+
+// identityTags returns the namespace and name tags without the UID. Instances
+// released with the Orphan deletion policy carry only these, which lets a later
+// MyResource with the same namespace and name adopt them.
+func (o Owner) identityTags() map[string]string {
+	return map[string]string{
+		OwnerNamespaceTag: o.Namespace,
+		OwnerNameTag:      o.Name,
+	}
+}
+
+// ownedBy reports whether tags contains every key/value pair in want.
+func ownedBy(tags, want map[string]string) bool {
+	for key, value := range want {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// orphanedBy reports whether tags mark an instance that was released by a
+// MyResource with the given identity and has not been claimed since.
+func orphanedBy(tags, identity map[string]string) bool {
+	if _, claimed := tags[OwnerUIDTag]; claimed {
+		return false
+	}
+	return ownedBy(tags, identity)
+}
+
+// gceLabels returns tags converted into valid GCE label keys and values.
+func gceLabels(tags map[string]string) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for _, key := range sortedKeys(tags) {
+		labels[gceLabelKey(key)] = gceLabelValue(tags[key])
+	}
+	return labels
+}
+
+// gceLabelValue converts s into a valid GCE label value: lowercase letters,
+// digits, '-' and '_', at most 63 characters.
+func gceLabelValue(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	value := b.String()
+	if len(value) > gceLabelMaxLength {
+		value = value[:gceLabelMaxLength]
+	}
+	return value
+}
@@ -0,0 +1,105 @@
+package cloudclients
+
+import "testing"
+
+func TestGCELabelValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "team-a", want: "team-a"},
+		{in: "My.Resource", want: "my_resource"},
+		{in: "0c6b1f9e-1b2a-4c7d-9e3f-5a6b7c8d9e0f", want: "0c6b1f9e-1b2a-4c7d-9e3f-5a6b7c8d9e0f"},
+		{
+			in:   "a-very-long-name-that-goes-well-beyond-the-sixty-three-character-limit",
+			want: "a-very-long-name-that-goes-well-beyond-the-sixty-three-characte",
+		},
+	}
+	for _, tt := range tests {
+		if got := gceLabelValue(tt.in); got != tt.want {
+			t.Errorf("gceLabelValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOwnedBy(t *testing.T) {
+	owner := Owner{Namespace: "default", Name: "web", UID: "1234"}
+
+	if !ownedBy(map[string]string{
+		OwnerNamespaceTag: "default",
+		OwnerNameTag:      "web",
+		OwnerUIDTag:       "1234",
+		"Name":            "web-0",
+	}, owner.Tags()) {
+		t.Error("expected instance with matching tags to be owned")
+	}
+
+	// Same name in the same namespace but a different incarnation of the MyResource.
+	if ownedBy(map[string]string{
+		OwnerNamespaceTag: "default",
+		OwnerNameTag:      "web",
+		OwnerUIDTag:       "5678",
+	}, owner.Tags()) {
+		t.Error("expected instance with a different UID not to be owned")
+	}
+
+	if ownedBy(map[string]string{"Name": "myresource-42"}, owner.Tags()) {
+		t.Error("expected untagged instance not to be owned")
+	}
+}
+
+func TestOrphanedBy(t *testing.T) {
+	owner := Owner{Namespace: "default", Name: "web", UID: "5678"}
+	tests := []struct {
+		name string
+		tags map[string]string
+		want bool
+	}{
+		{
+			// What the Orphan deletion policy leaves behind; adopted on purpose.
+			name: "released by a MyResource of the same name",
+			tags: map[string]string{OwnerNamespaceTag: "default", OwnerNameTag: "web", "Name": "web-0"},
+			want: true,
+		},
+		{
+			name: "still claimed",
+			tags: map[string]string{OwnerNamespaceTag: "default", OwnerNameTag: "web", OwnerUIDTag: "1234"},
+		},
+		{
+			name: "released by another MyResource",
+			tags: map[string]string{OwnerNamespaceTag: "default", OwnerNameTag: "api"},
+		},
+		{
+			name: "released in another namespace",
+			tags: map[string]string{OwnerNamespaceTag: "staging", OwnerNameTag: "web"},
+		},
+		{
+			name: "untagged",
+			tags: map[string]string{"Name": "web-0"},
+		},
+	}
+	for _, tt := range tests {
+		if got := orphanedBy(tt.tags, owner.identityTags()); got != tt.want {
+			t.Errorf("%s: orphanedBy() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGCELabelsRewritesKeys(t *testing.T) {
+	got := gceLabels(map[string]string{
+		"app.kubernetes.io/name": "Web",
+		"1st":                    "a",
+	})
+	want := map[string]string{
+		"app_kubernetes_io_name": "web",
+		"k1st":                   "a",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("gceLabels() = %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("gceLabels()[%q] = %q, want %q", key, got[key], value)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package cloudclients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	devopsv1 "github.com/andyzhang8/k8s-custom-controller/api/v1"
+)
+
+// Names of the built-in providers.
+const (
+	ProviderGCP   = "gcp"
+	ProviderAWS   = "aws"
+	ProviderAzure = "azure"
+)
+
+// ErrNoProvider is returned by Registry.Resolve when the spec does not select any provider.
+var ErrNoProvider = errors.New("no cloud provider configured")
+
+// Provider-neutral instance states. Each provider maps its native lifecycle onto these.
+const (
+	InstancePending     = "Pending"
+	InstanceRunning     = "Running"
+	InstanceStopping    = "Stopping"
+	InstanceStopped     = "Stopped"
+	InstanceFailed      = "Failed"
+	InstanceTerminating = "Terminating"
+	InstanceTerminated  = "Terminated"
+	InstanceUnknown     = "Unknown"
+)
+
+// Instance is a single virtual machine as reported by a Provider.
+type Instance struct {
+	// ID is the cloud-assigned identifier accepted by DeleteInstance and DescribeInstance.
+	ID   string
+	Name string
+	// State is one of the provider-neutral Instance* states.
+	State string
+	// Tags are the cloud tags (GCE labels) currently set on the instance.
+	Tags map[string]string
+	// Zone is the availability zone, or the region if the instance is not zonal.
+	Zone      string
+	PrivateIP string
+	PublicIP  string
+	CreatedAt time.Time
+	// Image is the image the instance booted from, in the cloud's own notation.
+	Image string
+}
+
+// Active reports whether the instance still counts towards the MyResource,
+// i.e. it is neither being terminated nor gone.
+func (i Instance) Active() bool {
+	return i.State != InstanceTerminating && i.State != InstanceTerminated
+}
+
+// InstanceRequest carries the inputs for a new instance that the controller
+// resolves from the cluster, such as Secrets, before calling CreateInstance.
+type InstanceRequest struct {
+	// Index is the 0-based ordinal of the instance within the MyResource.
+	Index int
+	// Name is the instance name, already passed through NameNormalizer.NormalizeName
+	// when the provider implements it.
+	Name string
+	// ClientToken is a UUID identifying the creation request. Retrying a
+	// request with the same Name and ClientToken returns the instance the
+	// first attempt created instead of creating another one.
+	ClientToken string
+	// AdminPassword is the password for the spec's admin user. Providers that
+	// cannot set a password at launch ignore it.
+	AdminPassword string
+	// Image is the concrete image to boot, as returned by ImageResolver.ResolveImage.
+	// When empty the provider resolves the spec's image itself.
+	Image string
+	// UserData is the bootstrap payload the instance runs on first boot, if any.
+	UserData string
+	// SSHUsername is the account SSHPublicKeys are authorized for, where the provider allows choosing one.
+	SSHUsername string
+	// SSHPublicKeys are OpenSSH public keys to authorize on the instance.
+	SSHPublicKeys []string
+	// Tags are written on the instance besides the ownership tags, which take precedence.
+	Tags map[string]string
+}
+
+// Provider manages the virtual machines backing a single MyResource.
+// Implementations are bound to the MyResource they were built for.
+type Provider interface {
+	// Name returns the registry name of the provider, e.g. "gcp".
+	Name() string
+	// ListInstances returns the instances carrying the ownership tags of the MyResource,
+	// including ones that are still starting or being terminated.
+	ListInstances(ctx context.Context) ([]Instance, error)
+	// CreateInstance provisions one new instance and returns it. If an instance
+	// owned by the MyResource already exists under req.Name, it is returned
+	// instead; one that is not owned yields ErrNotOwned.
+	CreateInstance(ctx context.Context, req InstanceRequest) (*Instance, error)
+	// DeleteInstance terminates the instance with the given ID. It returns
+	// ErrNotOwned if the instance does not belong to the MyResource.
+	DeleteInstance(ctx context.Context, id string) error
+	// DescribeInstance returns the current state of the instance with the given ID.
+	DescribeInstance(ctx context.Context, id string) (*Instance, error)
+	// ListOrphans returns instances released by an earlier MyResource with the
+	// same namespace and name, i.e. tagged with both but without an owner UID.
+	ListOrphans(ctx context.Context) ([]Instance, error)
+	// UpdateTags sets and removes tags (GCE labels) on the instance with the given ID.
+	UpdateTags(ctx context.Context, id string, set map[string]string, remove []string) error
+}
+
+// ImageResolver is implemented by providers whose spec can name an image
+// indirectly, e.g. by family or filter, so the controller can pin the image
+// it resolves to for later scale-ups.
+type ImageResolver interface {
+	// ImageSource returns the image reference configured in the spec.
+	ImageSource() string
+	// ResolveImage returns the concrete image ImageSource currently refers to.
+	ResolveImage(ctx context.Context) (string, error)
+}
+
+// NameNormalizer is implemented by providers that restrict instance names, so
+// the controller can check generated names stay distinct once rewritten.
+type NameNormalizer interface {
+	// NormalizeName returns name rewritten to fit the cloud's naming rules.
+	// Applying it twice gives the same result as applying it once.
+	NormalizeName(name string) string
+}
+
+// TagNormalizer is implemented by providers that rewrite tags to fit the
+// cloud's restrictions, so the controller can compare them with what
+// ListInstances reports.
+type TagNormalizer interface {
+	// NormalizeTags returns tags as the provider writes them on instances.
+	// Applying it twice gives the same result as applying it once.
+	NormalizeTags(tags map[string]string) map[string]string
+}
+
+// Cleaner is implemented by providers that create cloud resources besides
+// instances, such as imported key pairs.
+type Cleaner interface {
+	// Cleanup deletes the supporting resources created for the MyResource. It
+	// is called once all instances are gone.
+	Cleanup(ctx context.Context) error
+}
+
+// Selector reports whether a provider is selected by the given spec.
+type Selector func(spec *devopsv1.MyResourceSpec) bool
+
+// Factory builds a Provider for the given MyResource, authenticating with creds
+// when they are non-nil.
+type Factory func(ctx context.Context, res *devopsv1.MyResource, creds Credentials) (Provider, error)
+
+type registration struct {
+	name    string
+	selects Selector
+	factory Factory
+}
+
+// Registry maps provider names to the factories that build them.
+type Registry struct {
+	mu      sync.RWMutex
+	entries []registration
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewDefaultRegistry returns a Registry with the GCP, AWS and Azure providers registered.
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(ProviderGCP, func(spec *devopsv1.MyResourceSpec) bool { return spec.GCPConfig != nil }, NewGCPProvider)
+	r.Register(ProviderAWS, func(spec *devopsv1.MyResourceSpec) bool { return spec.AWSConfig != nil }, NewAWSProvider)
+	r.Register(ProviderAzure, func(spec *devopsv1.MyResourceSpec) bool { return spec.AzureConfig != nil }, NewAzureProvider)
+	return r
+}
+
+// Register adds a provider to the registry. Registering an existing name replaces it,
+// which lets tests swap a real cloud for a fake.
+func (r *Registry) Register(name string, selects Selector, factory Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry := registration{name: name, selects: selects, factory: factory}
+	for i := range r.entries {
+		if r.entries[i].name == name {
+			r.entries[i] = entry
+			return
+		}
+	}
+	r.entries = append(r.entries, entry)
+}
+
+// Select returns the name of the provider selected by the spec.
+func (r *Registry) Select(spec *devopsv1.MyResourceSpec) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matched []string
+	for _, entry := range r.entries {
+		if entry.selects(spec) {
+			matched = append(matched, entry.name)
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return "", ErrNoProvider
+	case 1:
+		return matched[0], nil
+	default:
+		return "", fmt.Errorf("multiple cloud providers configured: %s", strings.Join(matched, ", "))
+	}
+}
+
+// Resolve builds the provider selected by the MyResource spec. creds is the data
+// of the Secret named by CredentialsSecretName, or nil if there is none.
+func (r *Registry) Resolve(ctx context.Context, res *devopsv1.MyResource, creds Credentials) (Provider, error) {
+	name, err := r.Select(&res.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	var factory Factory
+	for _, entry := range r.entries {
+		if entry.name == name {
+			factory = entry.factory
+		}
+	}
+	r.mu.RUnlock()
+
+	provider, err := factory(ctx, res, creds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build %s provider: %w", name, err)
+	}
+	return provider, nil
+}
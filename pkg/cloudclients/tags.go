@@ -0,0 +1,112 @@
+package cloudclients
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Limits on instance names and user tags, in characters.
+const (
+	gceNameMaxLength   = 63
+	azureNameMaxLength = 64
+
+	ec2TagKeyMaxLength     = 128
+	ec2TagValueMaxLength   = 256
+	azureTagKeyMaxLength   = 512
+	azureTagValueMaxLength = 256
+)
+
+// ec2Tags returns tags rewritten to fit EC2: letters, digits, spaces and
+// _.:/=+-@ only, within the length limits. Keys reserved by AWS ("aws:") and
+// the Name tag, which the provider sets itself, are dropped.
+func ec2Tags(tags map[string]string) map[string]string {
+	result := make(map[string]string, len(tags))
+	for _, key := range sortedKeys(tags) {
+		name := truncate(replaceInvalid(key, ec2TagRune), ec2TagKeyMaxLength)
+		if name == "" || name == "Name" || strings.HasPrefix(strings.ToLower(name), "aws:") {
+			continue
+		}
+		result[name] = truncate(replaceInvalid(tags[key], ec2TagRune), ec2TagValueMaxLength)
+	}
+	return result
+}
+
+func ec2TagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" _.:/=+-@", r)
+}
+
+// azureTagsFor returns tags rewritten to fit Azure, whose tag names may not
+// contain any of <>%&\?/.
+func azureTagsFor(tags map[string]string) map[string]string {
+	result := make(map[string]string, len(tags))
+	for _, key := range sortedKeys(tags) {
+		name := truncate(replaceInvalid(key, azureTagNameRune), azureTagKeyMaxLength)
+		if name == "" {
+			continue
+		}
+		result[name] = truncate(tags[key], azureTagValueMaxLength)
+	}
+	return result
+}
+
+func azureTagNameRune(r rune) bool {
+	return !strings.ContainsRune(`<>%&\?/`, r)
+}
+
+// gceLabelKey converts s into a valid GCE label key. Keys follow the rules
+// for values but must also start with a lowercase letter, so others get a
+// "k" prefix.
+func gceLabelKey(s string) string {
+	key := gceLabelValue(s)
+	if key == "" || key[0] < 'a' || key[0] > 'z' {
+		key = gceLabelValue("k" + key)
+	}
+	return key
+}
+
+// dnsLabel converts s into a lowercase RFC 1035 label of at most max
+// characters: letters, digits and '-', starting with a letter and not ending
+// with '-'. Names not starting with a letter get an "m" prefix.
+func dnsLabel(s string, max int) string {
+	label := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(s))
+	if label == "" || label[0] < 'a' || label[0] > 'z' {
+		label = "m" + label
+	}
+	return strings.TrimRight(truncate(label, max), "-")
+}
+
+// replaceInvalid replaces every rune of s that valid rejects with '_'.
+func replaceInvalid(s string, valid func(rune) bool) string {
+	return strings.Map(func(r rune) rune {
+		if valid(r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// truncate shortens s to at most max characters.
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) > max {
+		return string(runes[:max])
+	}
+	return s
+}
+
+// sortedKeys returns the keys of m in order, so that keys which collide
+// after rewriting resolve the same way every time.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
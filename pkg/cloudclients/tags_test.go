@@ -0,0 +1,74 @@
+package cloudclients
+
+import "testing"
+
+func TestEC2Tags(t *testing.T) {
+	got := ec2Tags(map[string]string{
+		"team":           "payments & billing",
+		"aws:reserved":   "x",
+		"Name":           "override",
+		"cost-center/id": "cc-42",
+	})
+	want := map[string]string{
+		"team":           "payments _ billing",
+		"cost-center/id": "cc-42",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ec2Tags() = %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("ec2Tags()[%q] = %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestAzureTagsFor(t *testing.T) {
+	got := azureTagsFor(map[string]string{
+		"app.kubernetes.io/name": "web & api",
+	})
+	if len(got) != 1 || got["app.kubernetes.io_name"] != "web & api" {
+		t.Errorf("azureTagsFor() = %v", got)
+	}
+}
+
+func TestTagNormalizersAreIdempotent(t *testing.T) {
+	tags := map[string]string{
+		"App.Kubernetes.io/Name": "Web & API",
+		"9lives":                 "yes?",
+	}
+	for name, normalize := range map[string]func(map[string]string) map[string]string{
+		"ec2":   ec2Tags,
+		"gce":   gceLabels,
+		"azure": azureTagsFor,
+	} {
+		once := normalize(tags)
+		twice := normalize(once)
+		if len(once) != len(twice) {
+			t.Fatalf("%s: %v != %v", name, once, twice)
+		}
+		for key, value := range once {
+			if twice[key] != value {
+				t.Errorf("%s: normalizing again changed %q from %q to %q", name, key, value, twice[key])
+			}
+		}
+	}
+}
+
+func TestDNSLabel(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "web-3f9c2-0", want: "web-3f9c2-0"},
+		{in: "Team_A.web-1", want: "team-a-web-1"},
+		{in: "1st-vm", want: "m1st-vm"},
+		{in: "a-very-long-name-that-goes-well-beyond-the-sixty-three-character-limit", want: "a-very-long-name-that-goes-well-beyond-the-sixty-three-characte"},
+		{in: "name-ending-in-a-hyphen-once-it-is-cut-to-the-sixty-three-chars-", want: "name-ending-in-a-hyphen-once-it-is-cut-to-the-sixty-three-chars"},
+	}
+	for _, tt := range tests {
+		if got := dnsLabel(tt.in, gceNameMaxLength); got != tt.want {
+			t.Errorf("dnsLabel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -27,7 +27,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	"k8s-custom-controller/test/utils"
+	"github.com/andyzhang8/k8s-custom-controller/test/utils"
 )
 
 // namespace where the project is deployed in